@@ -0,0 +1,76 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+)
+
+// InjectorConfig configures the webhooks of a sidecar-go installation.
+// Installations sharing a cluster must use different classes, or different webhook configuration names.
+type InjectorConfig struct {
+	// Class is the injector class of the installation, which only injects the SidecarGo of this class.
+	Class string `json:"class,omitempty"`
+
+	// ServiceName is the Service routing to the webhook server.
+	ServiceName string `json:"serviceName,omitempty"`
+
+	// ServiceNamespace is the namespace of the Service, which is never injected.
+	ServiceNamespace string `json:"serviceNamespace,omitempty"`
+
+	// WebhookURL is called by the API server instead of the Service when set,
+	// e.g. to run the manager outside of the cluster.
+	WebhookURL string `json:"webhookURL,omitempty"`
+
+	// InjectPath is the path of the pod mutating webhook.
+	InjectPath string `json:"injectPath,omitempty"`
+
+	// ValidatePath is the path of the SidecarGo validating webhook.
+	ValidatePath string `json:"validatePath,omitempty"`
+
+	// ExcludedNamespaces are never injected.
+	ExcludedNamespaces []string `json:"excludedNamespaces,omitempty"`
+
+	// MutatingWebhookConfigurationName is the name of the MutatingWebhookConfiguration of the installation,
+	// defaults to sidecar-go-<class>-mutating-webhook-configuration, or sidecar-go-mutating-webhook-configuration without class.
+	MutatingWebhookConfigurationName string `json:"mutatingWebhookConfigurationName,omitempty"`
+
+	// ValidatingWebhookConfigurationName is the name of the ValidatingWebhookConfiguration of the installation,
+	// defaults like MutatingWebhookConfigurationName.
+	ValidatingWebhookConfigurationName string `json:"validatingWebhookConfigurationName,omitempty"`
+
+	// CertProvider is the source of the webhook certificates: self-signed, files or cert-manager.
+	CertProvider string `json:"certProvider,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// SidecarGoConfig is the Schema for the configuration file of the sidecar-go manager.
+// The webhook port and cert dir are read from the embedded webhook section.
+type SidecarGoConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// ControllerManagerConfigurationSpec returns the configurations for controllers
+	cfg.ControllerManagerConfigurationSpec `json:",inline"`
+
+	Injector InjectorConfig `json:"injector,omitempty"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SidecarGoConfig{})
+}
@@ -0,0 +1,286 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/togettoyou/sidecar-go/pkg/util"
+	"gomodules.xyz/jsonpatch/v2"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/yaml"
+)
+
+// DryRunPath is the path of the pod dry-run endpoint on the webhook server.
+// It takes a pod manifest, in JSON or YAML, and returns a DryRunResult.
+const DryRunPath = "/dry-run/pod"
+
+// DryRunUserHeader and DryRunGroupHeader carry the user the caller of the dry-run endpoint dry runs as,
+// when calling it through the API server service proxy, which drops the Authorization header.
+// The user is impersonated without authentication: who may call the endpoint is granted by the RBAC
+// on the services/proxy of the webhook Service.
+const (
+	DryRunUserHeader  = "X-Sidecar-Go-User"
+	DryRunGroupHeader = "X-Sidecar-Go-Group"
+)
+
+// maxDryRunPodSize bounds the size of the pod manifest read by the dry-run endpoint.
+const maxDryRunPodSize = 3 << 20
+
+// DryRunResult tells what the pod webhook would inject into a pod.
+type DryRunResult struct {
+	// Injected are the SidecarGo that would be injected into the pod, in injection order.
+	// It is empty when the pod would be denied.
+	Injected []string `json:"injected"`
+	// Skipped are the other loaded SidecarGo the caller can get, with the reason they would not be injected.
+	Skipped map[string]string `json:"skipped,omitempty"`
+	// Denied is why the pod would be denied.
+	Denied string `json:"denied,omitempty"`
+	// Patch is the JSON patch the pod webhook would respond with.
+	Patch []jsonpatch.JsonPatchOperation `json:"patch,omitempty"`
+}
+
+type podDryRun struct {
+	Client client.Client
+	// Store holds the SidecarGo to inject.
+	Store *util.SpecStore
+	// NativeSidecars is whether the cluster supports native sidecar containers.
+	NativeSidecars bool
+}
+
+//+kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// NewPodDryRun returns the handler of the pod dry-run endpoint. Pods without namespace
+// are dry run in the namespace query parameter, or in default.
+// Callers are authenticated by the bearer token of the Authorization header, or impersonate the user
+// of the DryRunUserHeader, and must be allowed to create pods in the namespace.
+func NewPodDryRun(c client.Client, store *util.SpecStore, nativeSidecars bool) http.Handler {
+	return &podDryRun{Client: c, Store: store, NativeSidecars: nativeSidecars}
+}
+
+func (pd *podDryRun) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	user, err := pd.authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxDryRunPodSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	raw, err := yaml.YAMLToJSON(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(raw, pod); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if pod.Kind != "" && pod.Kind != "Pod" {
+		http.Error(w, fmt.Sprintf("expected a Pod, got %s", pod.Kind), http.StatusBadRequest)
+		return
+	}
+	if pod.Namespace == "" {
+		pod.Namespace = r.URL.Query().Get("namespace")
+		if pod.Namespace == "" {
+			pod.Namespace = corev1.NamespaceDefault
+		}
+		// the pod webhook receives pods with their namespace, so the patch must not add it
+		raw, err = setNamespace(raw, pod.Namespace)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	podlog.Info("pod dry run", "name", pod.Name, "namespace", pod.Namespace, "user", user.Username)
+
+	allowed, err := pd.allowed(r.Context(), user, authorizationv1.ResourceAttributes{
+		Namespace: pod.Namespace,
+		Verb:      "create",
+		Resource:  "pods",
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, fmt.Sprintf("user %q cannot create pods in namespace %q", user.Username, pod.Namespace), http.StatusForbidden)
+		return
+	}
+
+	namespace := &corev1.Namespace{}
+	err = pd.Client.Get(r.Context(), types.NamespacedName{Name: pod.Namespace}, namespace)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if apierrors.IsNotFound(err) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	result, err := pd.Store.Inject(r.Context(), raw, pod, namespace, pd.NativeSidecars)
+	var injectErr *util.InjectError
+	if err != nil && !errors.As(err, &injectErr) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := DryRunResult{
+		Injected: make([]string, 0, len(result.Injected)),
+		Skipped:  result.Mismatched,
+	}
+	for namespacedName, reason := range result.Skipped {
+		response.Skipped[namespacedName] = reason
+	}
+	if err := pd.filterVisible(r.Context(), user, pod.Namespace, response.Skipped); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if injectErr != nil {
+		response.Denied = injectErr.Error()
+	} else {
+		for _, m := range result.Injected {
+			response.Injected = append(response.Injected, m.NamespacedName)
+		}
+		if result.Pod != nil {
+			response.Patch = admission.PatchResponseFromRaw(raw, result.Pod).Patches
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		podlog.Error(err, "unable to write dry run result")
+	}
+}
+
+// authenticate returns the user of the request: the user its bearer token belongs to,
+// or else the user of the DryRunUserHeader.
+func (pd *podDryRun) authenticate(r *http.Request) (*authenticationv1.UserInfo, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		username := r.Header.Get(DryRunUserHeader)
+		if username == "" {
+			return nil, fmt.Errorf("a bearer token or the %s header is required", DryRunUserHeader)
+		}
+		return &authenticationv1.UserInfo{Username: username, Groups: r.Header.Values(DryRunGroupHeader)}, nil
+	}
+	review := &authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token}}
+	if err := pd.Client.Create(r.Context(), review); err != nil {
+		return nil, err
+	}
+	if !review.Status.Authenticated {
+		return nil, fmt.Errorf("unauthenticated: %s", review.Status.Error)
+	}
+	return &review.Status.User, nil
+}
+
+// allowed reviews whether the user may access the resource.
+func (pd *podDryRun) allowed(ctx context.Context, user *authenticationv1.UserInfo, attributes authorizationv1.ResourceAttributes) (bool, error) {
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for key, value := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+	review := &authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+		ResourceAttributes: &attributes,
+		User:               user.Username,
+		Groups:             user.Groups,
+		UID:                user.UID,
+		Extra:              extra,
+	}}
+	if err := pd.Client.Create(ctx, review); err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
+
+// filterVisible drops from skipped the SidecarGo the user can not get: the SidecarGo of other namespaces,
+// the SidecarGo of the namespace without get on sidecargoes, and the ClusterSidecarGo without get on clustersidecargoes.
+func (pd *podDryRun) filterVisible(ctx context.Context, user *authenticationv1.UserInfo, namespace string, skipped map[string]string) error {
+	var sidecarGoAllowed, clusterSidecarGoAllowed bool
+	var err error
+	for namespacedName := range skipped {
+		if strings.Contains(namespacedName, "/") {
+			sidecarGoAllowed = true
+		} else {
+			clusterSidecarGoAllowed = true
+		}
+	}
+	if sidecarGoAllowed {
+		sidecarGoAllowed, err = pd.allowed(ctx, user, authorizationv1.ResourceAttributes{
+			Namespace: namespace,
+			Verb:      "get",
+			Group:     "apps.togettoyou.com",
+			Resource:  "sidecargoes",
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if clusterSidecarGoAllowed {
+		clusterSidecarGoAllowed, err = pd.allowed(ctx, user, authorizationv1.ResourceAttributes{
+			Verb:     "get",
+			Group:    "apps.togettoyou.com",
+			Resource: "clustersidecargoes",
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for namespacedName := range skipped {
+		sidecarGoNamespace, _, namespaced := strings.Cut(namespacedName, "/")
+		if namespaced && (!sidecarGoAllowed || sidecarGoNamespace != namespace) || !namespaced && !clusterSidecarGoAllowed {
+			delete(skipped, namespacedName)
+		}
+	}
+	return nil
+}
+
+// setNamespace sets the namespace of a raw pod, keeping the fields corev1.Pod does not know.
+func setNamespace(raw []byte, namespace string) ([]byte, error) {
+	var pod map[string]interface{}
+	if err := json.Unmarshal(raw, &pod); err != nil {
+		return nil, err
+	}
+	metadata, _ := pod["metadata"].(map[string]interface{})
+	if metadata == nil {
+		metadata = make(map[string]interface{})
+		pod["metadata"] = metadata
+	}
+	metadata["namespace"] = namespace
+	return json.Marshal(pod)
+}
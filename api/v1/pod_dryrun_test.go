@@ -0,0 +1,220 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/togettoyou/sidecar-go/api/v1alpha1"
+	"github.com/togettoyou/sidecar-go/pkg/util"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// reviewClient answers the TokenReviews and SubjectAccessReviews the fake client can not.
+type reviewClient struct {
+	client.Client
+	// users are the users by token.
+	users map[string]authenticationv1.UserInfo
+	// allowed are the "user-or-group verb resource" allowed.
+	allowed sets.String
+}
+
+func (c *reviewClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	switch review := obj.(type) {
+	case *authenticationv1.TokenReview:
+		review.Status.User, review.Status.Authenticated = c.users[review.Spec.Token]
+		return nil
+	case *authorizationv1.SubjectAccessReview:
+		attributes := review.Spec.ResourceAttributes
+		for _, subject := range append([]string{review.Spec.User}, review.Spec.Groups...) {
+			if c.allowed.Has(subject + " " + attributes.Verb + " " + attributes.Resource) {
+				review.Status.Allowed = true
+			}
+		}
+		return nil
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestPodDryRun(t *testing.T) {
+	store := util.NewSpecStore(nil, nil, "")
+	selector := func(app string) *metav1.LabelSelector {
+		return &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}}
+	}
+	for key, spec := range map[string]*v1alpha1.SidecarGoSpec{
+		"default/proxy": {Selector: selector("web"), Containers: []corev1.Container{{Name: "proxy", Image: "proxy:v1"}}},
+		"default/db":    {Selector: selector("db"), Containers: []corev1.Container{{Name: "db-proxy", Image: "proxy:v1"}}},
+		"other/proxy":   {Selector: selector("web"), Containers: []corev1.Container{{Name: "other", Image: "proxy:v1"}}},
+		"cluster":       {Selector: selector("db"), Containers: []corev1.Container{{Name: "cluster", Image: "proxy:v1"}}},
+	} {
+		if err := store.Update(key, spec); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+	}
+	c := &reviewClient{
+		Client: fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+		).Build(),
+		users: map[string]authenticationv1.UserInfo{
+			"alice-token": {Username: "alice"},
+			"bob-token":   {Username: "bob"},
+		},
+		allowed: sets.NewString(
+			"alice create pods", "alice get sidecargoes",
+			"admins create pods", "admins get sidecargoes", "admins get clustersidecargoes",
+		),
+	}
+	handler := NewPodDryRun(c, store, false)
+
+	pod := `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"web","labels":{"app":"web"}},"spec":{"containers":[{"name":"web","image":"web"}]}}`
+	tests := []struct {
+		name    string
+		method  string
+		query   string
+		headers map[string][]string
+		body    string
+		// wantStatus is the status code, the result is checked if it is 200.
+		wantStatus int
+		want       DryRunResult
+	}{
+		{
+			name:       "only POST",
+			method:     http.MethodGet,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "no credentials",
+			body:       pod,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "invalid token",
+			headers:    map[string][]string{"Authorization": {"Bearer unknown"}},
+			body:       pod,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "token of a user not allowed to create pods",
+			headers:    map[string][]string{"Authorization": {"Bearer bob-token"}},
+			body:       pod,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "not a pod",
+			headers:    map[string][]string{"Authorization": {"Bearer alice-token"}},
+			body:       `{"kind":"Deployment"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown namespace",
+			headers:    map[string][]string{"Authorization": {"Bearer alice-token"}},
+			query:      "?namespace=missing",
+			body:       pod,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "token of an allowed user sees the SidecarGo of the namespace",
+			headers:    map[string][]string{"Authorization": {"Bearer alice-token"}},
+			body:       pod,
+			wantStatus: http.StatusOK,
+			want: DryRunResult{
+				Injected: []string{"default/proxy"},
+				Skipped:  map[string]string{"default/db": util.MismatchReasonSelector},
+			},
+		},
+		{
+			name:       "user of the headers",
+			headers:    map[string][]string{DryRunUserHeader: {"carol"}, DryRunGroupHeader: {"devs", "admins"}},
+			body:       pod,
+			wantStatus: http.StatusOK,
+			want: DryRunResult{
+				Injected: []string{"default/proxy"},
+				Skipped:  map[string]string{"default/db": util.MismatchReasonSelector, "cluster": util.MismatchReasonSelector},
+			},
+		},
+		{
+			name:       "user of the headers not allowed to create pods",
+			headers:    map[string][]string{DryRunUserHeader: {"carol"}, DryRunGroupHeader: {"devs"}},
+			body:       pod,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "namespace of the query",
+			headers:    map[string][]string{"Authorization": {"Bearer alice-token"}},
+			query:      "?namespace=other",
+			body:       pod,
+			wantStatus: http.StatusOK,
+			want:       DryRunResult{Injected: []string{"other/proxy"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req := httptest.NewRequest(method, DryRunPath+tt.query, strings.NewReader(tt.body))
+			for key, values := range tt.headers {
+				for _, value := range values {
+					req.Header.Add(key, value)
+				}
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("ServeHTTP() status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			got := DryRunResult{}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !equality.Semantic.DeepEqual(got.Injected, tt.want.Injected) {
+				t.Errorf("ServeHTTP() injected = %v, want %v", got.Injected, tt.want.Injected)
+			}
+			if !equality.Semantic.DeepEqual(got.Skipped, tt.want.Skipped) {
+				t.Errorf("ServeHTTP() skipped = %v, want %v", got.Skipped, tt.want.Skipped)
+			}
+			// the pod is dry run in its namespace, which the patch of the pod webhook never adds
+			for _, operation := range got.Patch {
+				if strings.HasPrefix(operation.Path, "/metadata/namespace") {
+					t.Errorf("ServeHTTP() patch = %v, want no namespace", got.Patch)
+				}
+			}
+			if len(got.Patch) == 0 {
+				t.Error("ServeHTTP() patch is empty, want the injected containers")
+			}
+		})
+	}
+}
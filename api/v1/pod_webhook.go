@@ -19,10 +19,19 @@ package v1
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/togettoyou/sidecar-go/pkg/metrics"
 	"github.com/togettoyou/sidecar-go/pkg/util"
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -31,51 +40,141 @@ import (
 // log is for logging in this package.
 var podlog = logf.Log.WithName("pod-resource")
 
-//+kubebuilder:webhook:path=/mutate-core-v1-pod,mutating=true,failurePolicy=fail,sideEffects=None,groups=core,resources=pods,verbs=create;update,versions=v1,name=mpod.kb.io,admissionReviewVersions=v1
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+//+kubebuilder:webhook:path=/mutate-core-v1-pod,mutating=true,failurePolicy=fail,sideEffects=None,groups=core,resources=pods,verbs=create,versions=v1,name=mpod.kb.io,admissionReviewVersions=v1
 
 type podMutate struct {
-	Client  client.Client
-	decoder *admission.Decoder
+	Client client.Client
+	// APIReader reads the namespaces created too recently to be in the cache of Client.
+	APIReader client.Reader
+	// Store holds the SidecarGo to inject.
+	Store *util.SpecStore
+	// NativeSidecars is whether the cluster supports native sidecar containers,
+	// otherwise SidecarGo in native mode fall back to injecting containers.
+	NativeSidecars bool
+	// Recorder records the injection outcomes on the owners of the pods.
+	Recorder record.EventRecorder
+	decoder  *admission.Decoder
 }
 
-func NewPodMutate(c client.Client) admission.Handler {
-	return &podMutate{Client: c}
+func NewPodMutate(c client.Client, apiReader client.Reader, store *util.SpecStore, nativeSidecars bool, recorder record.EventRecorder) admission.Handler {
+	return &podMutate{Client: c, APIReader: apiReader, Store: store, NativeSidecars: nativeSidecars, Recorder: recorder}
 }
 
 func (pm *podMutate) Handle(ctx context.Context, req admission.Request) admission.Response {
+	// containers can not be added to running pods, nor requests checked again once the pod is admitted
+	if req.Operation != admissionv1.Create {
+		return admission.Allowed("")
+	}
 	podlog.Info("pod webhook")
+	metrics.PodsEvaluated.Inc()
+	defer func(start time.Time) {
+		metrics.HandleDuration.Observe(time.Since(start).Seconds())
+	}(time.Now())
 	pod := &corev1.Pod{}
 
 	err := pm.decoder.Decode(req, pod)
 	if err != nil {
+		recordErrored("")
 		return admission.Errored(http.StatusBadRequest, err)
 	}
 
-	specs, ok := util.PodMatchedSidecarGo(pod)
-	if !ok {
-		return admission.Allowed("")
+	namespace := &corev1.Namespace{}
+	err = pm.Client.Get(ctx, types.NamespacedName{Name: req.Namespace}, namespace)
+	if apierrors.IsNotFound(err) {
+		// the pod may be created right after its namespace
+		err = pm.APIReader.Get(ctx, types.NamespacedName{Name: req.Namespace}, namespace)
 	}
-	initContainers := make([]corev1.Container, 0)
-	containers := make([]corev1.Container, 0)
-	volumes := make([]corev1.Volume, 0)
-	for _, spec := range specs {
-		initContainers = append(initContainers, spec.InitContainers...)
-		containers = append(containers, spec.Containers...)
-		volumes = append(volumes, spec.Volumes...)
+	if err != nil {
+		recordErrored("")
+		return admission.Errored(http.StatusInternalServerError, err)
 	}
-	// 1.inject init containers
-	pod.Spec.InitContainers = append(pod.Spec.InitContainers, initContainers...)
-	// 2.inject containers
-	pod.Spec.Containers = util.MergeContainers(pod.Spec.Containers, containers)
-	// 3.inject volumes
-	pod.Spec.Volumes = util.MergeVolumes(pod.Spec.Volumes, volumes)
-
-	marshaledPod, err := json.Marshal(pod)
+
+	result, err := pm.Store.Inject(ctx, req.Object.Raw, pod, namespace, pm.NativeSidecars)
 	if err != nil {
+		var injectErr *util.InjectError
+		if errors.As(err, &injectErr) {
+			recordErrored(injectErr.NamespacedName)
+			pm.event(req, pod, corev1.EventTypeWarning, util.EventReasonInjectionDenied, injectErr.Error())
+			return admission.Denied(injectErr.Error())
+		}
+		recordErrored("")
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
+	if result.Pod == nil {
+		return admission.Allowed("")
+	}
 
-	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+	resp := admission.PatchResponseFromRaw(req.Object.Raw, result.Pod)
+	recordInjected(result, resp)
+	if len(result.Injected) > 0 {
+		names := make([]string, 0, len(result.Injected))
+		for _, m := range result.Injected {
+			names = append(names, m.NamespacedName)
+		}
+		pm.event(req, pod, corev1.EventTypeNormal, util.EventReasonInjected, "injected SidecarGo "+strings.Join(names, ", "))
+	}
+	return resp
+}
+
+// event records an event about the pod on its controller, or on the pod when it has none.
+// Pods are not persisted yet at admission, so events on their owner are what users see,
+// and the recorder aggregates the similar events of the pods of an owner.
+func (pm *podMutate) event(req admission.Request, pod *corev1.Pod, eventtype, reason, message string) {
+	podName := pod.Name
+	if podName == "" {
+		podName = pod.GenerateName
+	}
+	ref := &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  req.Namespace,
+		Name:       pod.Name,
+		UID:        pod.UID,
+	}
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		ref = &corev1.ObjectReference{
+			APIVersion: owner.APIVersion,
+			Kind:       owner.Kind,
+			Namespace:  req.Namespace,
+			Name:       owner.Name,
+			UID:        owner.UID,
+		}
+	} else if pod.Name == "" {
+		return
+	}
+	pm.Recorder.Eventf(ref, eventtype, reason, "pod %s: %s", podName, message)
+}
+
+// recordInjected records the injected and skipped SidecarGo, and the size of the patch.
+func recordInjected(result *util.InjectResult, resp admission.Response) {
+	for _, m := range result.Injected {
+		namespace, name := splitNamespacedName(m.NamespacedName)
+		metrics.PodsInjected.WithLabelValues(name, namespace).Inc()
+	}
+	for namespacedName, reason := range result.Skipped {
+		namespace, name := splitNamespacedName(namespacedName)
+		metrics.PodsSkipped.WithLabelValues(name, namespace, reason).Inc()
+	}
+	if patch, err := json.Marshal(resp.Patches); err == nil {
+		metrics.PatchSize.Observe(float64(len(patch)))
+	}
+}
+
+// recordErrored records a pod denied by the SidecarGo, or failed if namespacedName is empty.
+func recordErrored(namespacedName string) {
+	namespace, name := splitNamespacedName(namespacedName)
+	metrics.PodsErrored.WithLabelValues(name, namespace).Inc()
+}
+
+// splitNamespacedName splits the key of a SidecarGo, ClusterSidecarGo keys have no namespace.
+func splitNamespacedName(namespacedName string) (namespace, name string) {
+	namespace, name, ok := strings.Cut(namespacedName, "/")
+	if !ok {
+		return "", namespacedName
+	}
+	return namespace, name
 }
 
 // InjectDecoder injects the decoder.
@@ -0,0 +1,118 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/togettoyou/sidecar-go/api/v1alpha1"
+	"github.com/togettoyou/sidecar-go/pkg/util"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestPodMutateHandle(t *testing.T) {
+	decoder, err := admission.NewDecoder(clientgoscheme.Scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := util.NewSpecStore(nil, nil, "")
+	err = store.Update("proxy", &v1alpha1.SidecarGoSpec{
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+		Selector:          &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		Containers:        []corev1.Container{{Name: "proxy", Image: "proxy:v1"}},
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	prod := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "prod", Labels: map[string]string{"env": "prod"}}}
+	newClient := func(objs ...client.Object) client.Client {
+		return fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(objs...).Build()
+	}
+	raw, err := json.Marshal(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "prod", Labels: map[string]string{"app": "web"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "web"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		cache     client.Client
+		apiReader client.Reader
+		// wantCode is the code of an errored response, 0 if the pod is injected.
+		wantCode int32
+	}{
+		{
+			name:      "namespace in the cache",
+			cache:     newClient(prod),
+			apiReader: newClient(),
+		},
+		{
+			name:      "namespace missing from the cache",
+			cache:     newClient(),
+			apiReader: newClient(prod),
+		},
+		{
+			name:      "namespace not found",
+			cache:     newClient(),
+			apiReader: newClient(),
+			wantCode:  http.StatusInternalServerError,
+		},
+		{
+			name:      "cache failing is not retried",
+			cache:     fake.NewClientBuilder().WithScheme(runtime.NewScheme()).Build(),
+			apiReader: newClient(prod),
+			wantCode:  http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := NewPodMutate(tt.cache, tt.apiReader, store, false, record.NewFakeRecorder(10))
+			if err := pm.(admission.DecoderInjector).InjectDecoder(decoder); err != nil {
+				t.Fatal(err)
+			}
+			resp := pm.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Name:      "web",
+				Namespace: "prod",
+				Object:    runtime.RawExtension{Raw: raw},
+			}})
+			if tt.wantCode != 0 {
+				if resp.Allowed || resp.Result.Code != tt.wantCode {
+					t.Errorf("Handle() = %+v, want code %d", resp.Result, tt.wantCode)
+				}
+				return
+			}
+			if !resp.Allowed || len(resp.Patches) == 0 {
+				t.Errorf("Handle() allowed = %v, patches = %v, want the pod injected", resp.Allowed, resp.Patches)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Class",type=string,JSONPath=`.spec.class`,priority=1
+//+kubebuilder:printcolumn:name="Matched",type=integer,JSONPath=`.status.matchedPods`
+//+kubebuilder:printcolumn:name="Injected",type=integer,JSONPath=`.status.injectedPods`
+//+kubebuilder:printcolumn:name="Outdated",type=integer,JSONPath=`.status.outdatedPods`
+//+kubebuilder:printcolumn:name="Skipped",type=integer,JSONPath=`.status.skippedPods`,priority=1
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ClusterSidecarGo is the Schema for the clustersidecargoes API.
+// It injects the pods of all namespaces, restricted by namespace and namespaceSelector.
+type ClusterSidecarGo struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SidecarGoSpec   `json:"spec,omitempty"`
+	Status SidecarGoStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ClusterSidecarGoList contains a list of ClusterSidecarGo
+type ClusterSidecarGoList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterSidecarGo `json:"items"`
+}
+
+func (s *ClusterSidecarGo) GetSpec() *SidecarGoSpec {
+	return &s.Spec
+}
+
+func (s *ClusterSidecarGo) GetStatus() *SidecarGoStatus {
+	return &s.Status
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterSidecarGo{}, &ClusterSidecarGoList{})
+}
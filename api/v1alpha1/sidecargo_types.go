@@ -19,8 +19,14 @@ package v1alpha1
 import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// LabelClass is the label of the SidecarGo of a non-empty class, set to the class.
+// The validating webhook of each installation only selects the SidecarGo labeled with its class,
+// or without the label for installations without class, so that installations do not depend on each other.
+const LabelClass = "sidecar-go.togettoyou.com/class"
+
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
@@ -29,8 +35,91 @@ type SidecarGoSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	Selector  *metav1.LabelSelector `json:"selector,omitempty"`
-	Namespace string                `json:"namespace,omitempty"`
+	// Class selects the sidecar-go installation that injects this SidecarGo.
+	// Each installation only loads the SidecarGo of its own --class, the empty class by default.
+	// SidecarGo of a non-empty class must be labeled with it, see LabelClass.
+	// +optional
+	Class string `json:"class,omitempty"`
+
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// Namespace restricts injection to pods of a single namespace.
+	// A SidecarGo only injects the pods of its own namespace, so it can only be set to it.
+	// Deprecated: use NamespaceSelector.
+	Namespace string `json:"namespace,omitempty"`
+
+	// NamespaceSelector restricts injection to pods of the namespaces whose labels match.
+	// Only supported by ClusterSidecarGo.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// ExcludeSelector excludes the matched pods whose labels match, e.g. canary pods.
+	// An empty selector excludes no pod.
+	// +optional
+	ExcludeSelector *metav1.LabelSelector `json:"excludeSelector,omitempty"`
+
+	// ExcludeNamespaces excludes the pods of the namespaces from injection.
+	// +optional
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+
+	// OwnerKinds restricts injection to pods controlled by one of the workload kinds, e.g. Deployment,
+	// StatefulSet, DaemonSet, Job or CronJob. Pods of a ReplicaSet or Job are controlled by the Deployment
+	// or CronJob owning it, if any.
+	// +optional
+	OwnerKinds []string `json:"ownerKinds,omitempty"`
+
+	// ServiceAccountNames restricts injection to pods running as one of the service accounts.
+	// +optional
+	ServiceAccountNames []string `json:"serviceAccountNames,omitempty"`
+
+	// PriorityClassNames restricts injection to pods of one of the priority classes.
+	// +optional
+	PriorityClassNames []string `json:"priorityClassNames,omitempty"`
+
+	// AnnotationSelector restricts injection to pods whose annotations match.
+	// +optional
+	AnnotationSelector *metav1.LabelSelector `json:"annotationSelector,omitempty"`
+
+	// ContainerSelector restricts injection to pods with a container matching it.
+	// +optional
+	ContainerSelector *ContainerSelector `json:"containerSelector,omitempty"`
+
+	// NodeSelector restricts injection to pods whose spec.nodeSelector matches it, spec.nodeName
+	// being matched as the kubernetes.io/hostname label. Pods scheduled by affinity alone are not matched.
+	// +optional
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+
+	// Requestable lets pods request the SidecarGo by name in their sidecar-go.togettoyou.com/inject annotation,
+	// e.g. "log-agent,tracer", in addition to the pods matched by the selector.
+	// Requests are still restricted by namespace, namespaceSelector and the other pod matchers.
+	// +optional
+	Requestable bool `json:"requestable,omitempty"`
+
+	// Priority orders the injection when several SidecarGo match a pod.
+	// Containers of SidecarGo with higher priority are injected first,
+	// SidecarGo with the same priority are ordered by namespace/name.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+
+	// MergePolicy defines how injected init containers, containers and volumes are merged
+	// with the ones of the same name already in the pod.
+	// When unset, containers are overwritten and volumes of the pod are kept.
+	// +optional
+	MergePolicy MergePolicy `json:"mergePolicy,omitempty"`
+
+	// SidecarMode defines how the containers are injected.
+	// With native, they are injected as init containers with restartPolicy Always (Kubernetes native sidecars),
+	// so that they start before and stop after the app containers, and Jobs can complete.
+	// Clusters without native sidecar support fall back to container.
+	// +optional
+	SidecarMode SidecarMode `json:"sidecarMode,omitempty"`
+
+	// Templated renders the strings of initContainers, containers, volumes and containerPatches
+	// as Go templates against the pod before injecting them, e.g. "{{ .Name }}" or "{{ .Labels.app }}".
+	// Templates are parsed when the SidecarGo is admitted, and pods they fail to render for are denied.
+	// Templated container and volume names are validated by the API server once rendered.
+	// +optional
+	Templated bool `json:"templated,omitempty"`
 
 	// +kubebuilder:pruning:PreserveUnknownFields
 	// +kubebuilder:validation:Schemaless
@@ -43,18 +132,161 @@ type SidecarGoSpec struct {
 	// +kubebuilder:pruning:PreserveUnknownFields
 	// +kubebuilder:validation:Schemaless
 	Volumes []corev1.Volume `json:"volumes,omitempty"`
+
+	// ContainerPatches add env, envFrom, volumeMounts and resources to the containers of the pod itself.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Schemaless
+	ContainerPatches []ContainerPatch `json:"containerPatches,omitempty"`
+
+	// Rollout restarts the workloads owning already running pods that miss the sidecars.
+	// +optional
+	Rollout *RolloutSpec `json:"rollout,omitempty"`
+}
+
+// ContainerSelector selects pods by their containers
+type ContainerSelector struct {
+	// Names are shell patterns like "app-*" one of which the container name must match.
+	// All names match when empty.
+	Names []string `json:"names,omitempty"`
+
+	// Images are shell patterns like "nginx:*" one of which the container image must match,
+	// "*" not matching "/". All images match when empty.
+	Images []string `json:"images,omitempty"`
+}
+
+// ContainerPatch defines what is added to selected containers of the pod
+type ContainerPatch struct {
+	// Containers selects the containers of the pod by name, supporting shell patterns like "app-*".
+	// All containers of the pod are selected when empty. Injected containers are never selected.
+	Containers []string `json:"containers,omitempty"`
+
+	Env          []corev1.EnvVar             `json:"env,omitempty"`
+	EnvFrom      []corev1.EnvFromSource      `json:"envFrom,omitempty"`
+	VolumeMounts []corev1.VolumeMount        `json:"volumeMounts,omitempty"`
+	Resources    corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// SidecarMode defines where sidecar containers are injected
+// +kubebuilder:validation:Enum=container;native
+type SidecarMode string
+
+const (
+	// SidecarModeContainer injects sidecars into the containers of the pod.
+	SidecarModeContainer SidecarMode = "container"
+	// SidecarModeNative injects sidecars into the init containers of the pod with restartPolicy Always.
+	SidecarModeNative SidecarMode = "native"
+)
+
+// MergePolicy defines how an injected item is merged with an item of the same name in the pod
+// +kubebuilder:validation:Enum=overwrite;skip;fail;merge
+type MergePolicy string
+
+const (
+	// MergePolicyOverwrite replaces the item of the pod with the injected one.
+	MergePolicyOverwrite MergePolicy = "overwrite"
+	// MergePolicySkip keeps the item of the pod and drops the injected one.
+	MergePolicySkip MergePolicy = "skip"
+	// MergePolicyFail denies the pod.
+	MergePolicyFail MergePolicy = "fail"
+	// MergePolicyMerge merges env, envFrom, volumeMounts and resources of the injected container
+	// into the container of the pod. Volumes can not be merged and are kept as in the pod.
+	MergePolicyMerge MergePolicy = "merge"
+)
+
+// RolloutSpec defines how the owning workloads of matched pods are restarted
+type RolloutSpec struct {
+	// Enabled turns on restarting Deployments, StatefulSets and DaemonSets
+	// whose pods run without the current sidecars.
+	Enabled bool `json:"enabled,omitempty"`
+
+	// BatchSize is the maximum number of workloads restarting at the same time.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1
+	// +optional
+	BatchSize int32 `json:"batchSize,omitempty"`
+
+	// PauseSeconds is the pause between two batches of restarts.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	PauseSeconds int32 `json:"pauseSeconds,omitempty"`
+
+	// ProgressDeadlineSeconds is how long a restarted workload may take to replace its pods,
+	// after which it is reported as stalled and no longer holds a slot of the batch.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=600
+	// +optional
+	ProgressDeadlineSeconds int32 `json:"progressDeadlineSeconds,omitempty"`
 }
 
 // SidecarGoStatus defines the observed state of SidecarGo
 type SidecarGoStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	// ObservedGeneration is the most recent generation observed by the controller.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// MatchedPods is the number of pods currently matching the selector, including the skipped ones.
+	MatchedPods int32 `json:"matchedPods,omitempty"`
+
+	// SkippedPods is the number of matching pods opting out of this SidecarGo with an annotation.
+	// They are never injected nor restarted, and are not reported as missing sidecars.
+	SkippedPods int32 `json:"skippedPods,omitempty"`
+
+	// InjectedPods is the number of matching pods carrying the injected containers.
+	InjectedPods int32 `json:"injectedPods,omitempty"`
+
+	// OutdatedPods is the number of injected pods running an outdated revision of the spec.
+	OutdatedPods int32 `json:"outdatedPods,omitempty"`
+
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// Rollout reports the progress of restarting the owning workloads of matched pods.
+	// +optional
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
 }
 
+// RolloutStatus defines the observed state of the rollout
+type RolloutStatus struct {
+	// PendingWorkloads is the number of workloads waiting to be restarted.
+	PendingWorkloads int32 `json:"pendingWorkloads,omitempty"`
+
+	// UpdatingWorkloads is the number of restarted workloads whose pods are not updated yet.
+	UpdatingWorkloads int32 `json:"updatingWorkloads,omitempty"`
+
+	// StalledWorkloads is the number of restarted workloads whose pods were not updated within the progress deadline.
+	StalledWorkloads int32 `json:"stalledWorkloads,omitempty"`
+
+	// SkippedWorkloads is the number of workloads that are not restarted, as their update strategy is OnDelete.
+	SkippedWorkloads int32 `json:"skippedWorkloads,omitempty"`
+
+	// LastBatchTime is the time the last batch of workloads was restarted.
+	// +optional
+	LastBatchTime *metav1.Time `json:"lastBatchTime,omitempty"`
+}
+
+const (
+	// ConditionReady indicates the spec has been loaded and is used by the webhook.
+	ConditionReady = "Ready"
+	// ConditionDegraded indicates matching pods are missing or running outdated sidecars.
+	ConditionDegraded = "Degraded"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Class",type=string,JSONPath=`.spec.class`,priority=1
+//+kubebuilder:printcolumn:name="Matched",type=integer,JSONPath=`.status.matchedPods`
+//+kubebuilder:printcolumn:name="Injected",type=integer,JSONPath=`.status.injectedPods`
+//+kubebuilder:printcolumn:name="Outdated",type=integer,JSONPath=`.status.outdatedPods`
+//+kubebuilder:printcolumn:name="Skipped",type=integer,JSONPath=`.status.skippedPods`,priority=1
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
-// SidecarGo is the Schema for the sidecargoes API
+// SidecarGo is the Schema for the sidecargoes API.
+// It injects the pods of its own namespace.
 type SidecarGo struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -72,6 +304,22 @@ type SidecarGoList struct {
 	Items           []SidecarGo `json:"items"`
 }
 
+// SidecarGoObject is a SidecarGo or a ClusterSidecarGo.
+// +kubebuilder:object:generate=false
+type SidecarGoObject interface {
+	client.Object
+	GetSpec() *SidecarGoSpec
+	GetStatus() *SidecarGoStatus
+}
+
+func (s *SidecarGo) GetSpec() *SidecarGoSpec {
+	return &s.Spec
+}
+
+func (s *SidecarGo) GetStatus() *SidecarGoStatus {
+	return &s.Status
+}
+
 func init() {
 	SchemeBuilder.Register(&SidecarGo{}, &SidecarGoList{})
 }
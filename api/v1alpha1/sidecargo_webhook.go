@@ -0,0 +1,378 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/togettoyou/sidecar-go/pkg/template"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var sidecargolog = logf.Log.WithName("sidecargo-resource")
+
+//+kubebuilder:webhook:path=/validate-apps-togettoyou-com-v1alpha1-sidecargo,mutating=false,failurePolicy=fail,sideEffects=None,groups=apps.togettoyou.com,resources=sidecargoes;clustersidecargoes,verbs=create;update,versions=v1alpha1,name=vsidecargo.kb.io,admissionReviewVersions=v1
+
+type sidecarGoValidate struct {
+	Client  client.Client
+	decoder *admission.Decoder
+}
+
+func NewSidecarGoValidate(c client.Client) admission.Handler {
+	return &sidecarGoValidate{Client: c}
+}
+
+func (sv *sidecarGoValidate) Handle(ctx context.Context, req admission.Request) admission.Response {
+	sidecargolog.Info("sidecargo webhook", "kind", req.Kind.Kind, "name", req.Name, "namespace", req.Namespace)
+	var sidecarGo SidecarGoObject = &SidecarGo{}
+	if req.Kind.Kind == "ClusterSidecarGo" {
+		sidecarGo = &ClusterSidecarGo{}
+	}
+
+	err := sv.decoder.Decode(req, sidecarGo)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	errs := validateStrict(req.Object.Raw)
+	errs = append(errs, ValidateSidecarGoSpec(sidecarGo.GetSpec(), field.NewPath("spec"))...)
+	errs = append(errs, validateClassLabel(sidecarGo)...)
+	if sidecarGo.GetNamespace() != "" {
+		errs = append(errs, validateNamespaced(sidecarGo, field.NewPath("spec"))...)
+	}
+	if len(errs) == 0 {
+		others, err := ListSidecarGoObjects(ctx, sv.Client)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		for _, other := range others {
+			if (KindOf(other) == KindOf(sidecarGo) && KeyOf(other) == KeyOf(sidecarGo)) ||
+				other.GetSpec().Class != sidecarGo.GetSpec().Class {
+				continue
+			}
+			for _, name := range ConflictingContainers(sidecarGo, other) {
+				errs = append(errs, field.Duplicate(field.NewPath("spec", "containers"),
+					fmt.Sprintf("%s (also injected by %s %s)", name, KindOf(other), KeyOf(other))))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return admission.Denied(errs.ToAggregate().Error())
+	}
+	return admission.Allowed("")
+}
+
+// InjectDecoder injects the decoder.
+func (sv *sidecarGoValidate) InjectDecoder(d *admission.Decoder) error {
+	sv.decoder = d
+	return nil
+}
+
+// validateStrict decodes the schemaless fields of the spec, rejecting unknown fields.
+func validateStrict(raw []byte) field.ErrorList {
+	var obj struct {
+		Spec struct {
+			InitContainers   json.RawMessage `json:"initContainers"`
+			Containers       json.RawMessage `json:"containers"`
+			Volumes          json.RawMessage `json:"volumes"`
+			ContainerPatches json.RawMessage `json:"containerPatches"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return field.ErrorList{field.Forbidden(field.NewPath("spec"), err.Error())}
+	}
+
+	errs := field.ErrorList{}
+	specPath := field.NewPath("spec")
+	for _, value := range []struct {
+		path *field.Path
+		raw  json.RawMessage
+		out  interface{}
+	}{
+		{specPath.Child("initContainers"), obj.Spec.InitContainers, &[]corev1.Container{}},
+		{specPath.Child("containers"), obj.Spec.Containers, &[]corev1.Container{}},
+		{specPath.Child("volumes"), obj.Spec.Volumes, &[]corev1.Volume{}},
+		{specPath.Child("containerPatches"), obj.Spec.ContainerPatches, &[]ContainerPatch{}},
+	} {
+		if len(value.raw) == 0 {
+			continue
+		}
+		decoder := json.NewDecoder(bytes.NewReader(value.raw))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(value.out); err != nil {
+			errs = append(errs, field.Forbidden(value.path, err.Error()))
+		}
+	}
+	return errs
+}
+
+// ValidateSidecarGoSpec validates the selectors, the container patterns, and the names of the injected containers and volumes.
+func ValidateSidecarGoSpec(spec *SidecarGoSpec, fldPath *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	if spec.Selector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(spec.Selector); err != nil {
+			errs = append(errs, field.Invalid(fldPath.Child("selector"), spec.Selector, err.Error()))
+		}
+	}
+	if spec.NamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(spec.NamespaceSelector); err != nil {
+			errs = append(errs, field.Invalid(fldPath.Child("namespaceSelector"), spec.NamespaceSelector, err.Error()))
+		}
+	}
+	if spec.AnnotationSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(spec.AnnotationSelector); err != nil {
+			errs = append(errs, field.Invalid(fldPath.Child("annotationSelector"), spec.AnnotationSelector, err.Error()))
+		}
+	}
+	if spec.NodeSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(spec.NodeSelector); err != nil {
+			errs = append(errs, field.Invalid(fldPath.Child("nodeSelector"), spec.NodeSelector, err.Error()))
+		}
+	}
+	if spec.ExcludeSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(spec.ExcludeSelector); err != nil {
+			errs = append(errs, field.Invalid(fldPath.Child("excludeSelector"), spec.ExcludeSelector, err.Error()))
+		}
+	}
+	if spec.ContainerSelector != nil {
+		for _, patterns := range []struct {
+			path  *field.Path
+			items []string
+		}{
+			{fldPath.Child("containerSelector", "names"), spec.ContainerSelector.Names},
+			{fldPath.Child("containerSelector", "images"), spec.ContainerSelector.Images},
+		} {
+			for i, pattern := range patterns.items {
+				if _, err := path.Match(pattern, ""); err != nil {
+					errs = append(errs, field.Invalid(patterns.path.Index(i), pattern, err.Error()))
+				}
+			}
+		}
+	}
+
+	containerNames := sets.NewString()
+	for _, containers := range []struct {
+		path  *field.Path
+		items []corev1.Container
+	}{
+		{fldPath.Child("initContainers"), spec.InitContainers},
+		{fldPath.Child("containers"), spec.Containers},
+	} {
+		for i, container := range containers.items {
+			idxPath := containers.path.Index(i)
+			errs = append(errs, validateName(spec, container.Name, idxPath.Child("name"))...)
+			if containerNames.Has(container.Name) {
+				errs = append(errs, field.Duplicate(idxPath.Child("name"), container.Name))
+			}
+			containerNames.Insert(container.Name)
+			if container.Image == "" {
+				errs = append(errs, field.Required(idxPath.Child("image"), ""))
+			}
+		}
+	}
+
+	volumeNames := sets.NewString()
+	for i, volume := range spec.Volumes {
+		idxPath := fldPath.Child("volumes").Index(i)
+		errs = append(errs, validateName(spec, volume.Name, idxPath.Child("name"))...)
+		if volumeNames.Has(volume.Name) {
+			errs = append(errs, field.Duplicate(idxPath.Child("name"), volume.Name))
+		}
+		volumeNames.Insert(volume.Name)
+	}
+
+	if spec.Templated {
+		for _, part := range []struct {
+			name  string
+			value interface{}
+		}{
+			{"initContainers", spec.InitContainers},
+			{"containers", spec.Containers},
+			{"volumes", spec.Volumes},
+			{"containerPatches", spec.ContainerPatches},
+		} {
+			if err := template.Validate(part.name, part.value); err != nil {
+				var templateErr *template.Error
+				if errors.As(err, &templateErr) {
+					errs = append(errs, field.Invalid(fldPath.Child(templateErr.Path), templateErr.Template, templateErr.Err.Error()))
+				} else {
+					errs = append(errs, field.InternalError(fldPath.Child(part.name), err))
+				}
+			}
+		}
+	}
+
+	for i, patch := range spec.ContainerPatches {
+		for j, pattern := range patch.Containers {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, field.Invalid(fldPath.Child("containerPatches").Index(i).Child("containers").Index(j), pattern, err.Error()))
+			}
+		}
+	}
+
+	return errs
+}
+
+// validateClassLabel requires LabelClass to match the class, so that the SidecarGo is validated by its installation.
+func validateClassLabel(sidecarGo SidecarGoObject) field.ErrorList {
+	class := sidecarGo.GetSpec().Class
+	label, ok := sidecarGo.GetLabels()[LabelClass]
+	fldPath := field.NewPath("metadata", "labels").Key(LabelClass)
+	if class == "" {
+		if ok {
+			return field.ErrorList{field.Forbidden(fldPath, "only set for SidecarGo with a spec.class")}
+		}
+		return nil
+	}
+	if label == class {
+		return nil
+	}
+	return field.ErrorList{field.Invalid(fldPath, label, fmt.Sprintf("must be set to the spec.class %q", class))}
+}
+
+// validateNamespaced restricts a SidecarGo to the pods of its own namespace.
+func validateNamespaced(sidecarGo SidecarGoObject, fldPath *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+	spec := sidecarGo.GetSpec()
+	if spec.Namespace != "" && spec.Namespace != sidecarGo.GetNamespace() {
+		errs = append(errs, field.Invalid(fldPath.Child("namespace"), spec.Namespace,
+			"a SidecarGo only injects the pods of its own namespace, use a ClusterSidecarGo to inject other namespaces"))
+	}
+	if spec.NamespaceSelector != nil {
+		errs = append(errs, field.Forbidden(fldPath.Child("namespaceSelector"), "only supported by ClusterSidecarGo"))
+	}
+	return errs
+}
+
+// validateName validates a container or volume name. Templated names are only checked once rendered,
+// by the API server when the pod is created.
+func validateName(spec *SidecarGoSpec, name string, fldPath *field.Path) field.ErrorList {
+	if name == "" {
+		return field.ErrorList{field.Required(fldPath, "")}
+	}
+	if spec.Templated && template.IsTemplate(name) {
+		return nil
+	}
+	errs := field.ErrorList{}
+	for _, msg := range validation.IsDNS1123Label(name) {
+		errs = append(errs, field.Invalid(fldPath, name, msg))
+	}
+	return errs
+}
+
+// ListSidecarGoObjects lists all SidecarGo and ClusterSidecarGo.
+func ListSidecarGoObjects(ctx context.Context, c client.Reader) ([]SidecarGoObject, error) {
+	sidecarGos := &SidecarGoList{}
+	if err := c.List(ctx, sidecarGos); err != nil {
+		return nil, err
+	}
+	clusterSidecarGos := &ClusterSidecarGoList{}
+	if err := c.List(ctx, clusterSidecarGos); err != nil {
+		return nil, err
+	}
+	objs := make([]SidecarGoObject, 0, len(sidecarGos.Items)+len(clusterSidecarGos.Items))
+	for i := range sidecarGos.Items {
+		objs = append(objs, &sidecarGos.Items[i])
+	}
+	for i := range clusterSidecarGos.Items {
+		objs = append(objs, &clusterSidecarGos.Items[i])
+	}
+	return objs, nil
+}
+
+// KindOf returns SidecarGo or ClusterSidecarGo.
+func KindOf(obj SidecarGoObject) string {
+	if _, ok := obj.(*ClusterSidecarGo); ok {
+		return "ClusterSidecarGo"
+	}
+	return "SidecarGo"
+}
+
+// KeyOf returns the key the SidecarGo is recorded with on pods:
+// namespace/name for a SidecarGo, and name for a ClusterSidecarGo.
+func KeyOf(obj client.Object) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetName()
+	}
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// ConflictingContainers returns the names of the containers injected by both SidecarGo,
+// when both may match the same pods. SidecarGo of different classes are injected by
+// different installations, callers only compare SidecarGo of the same class.
+func ConflictingContainers(aObj, bObj SidecarGoObject) []string {
+	if !mayOverlap(aObj, bObj) {
+		return nil
+	}
+	a, b := aObj.GetSpec(), bObj.GetSpec()
+	names := sets.NewString()
+	for _, containers := range [][]corev1.Container{b.InitContainers, b.Containers} {
+		for _, container := range containers {
+			names.Insert(container.Name)
+		}
+	}
+	conflicts := sets.NewString()
+	for _, containers := range [][]corev1.Container{a.InitContainers, a.Containers} {
+		for _, container := range containers {
+			if names.Has(container.Name) {
+				conflicts.Insert(container.Name)
+			}
+		}
+	}
+	return conflicts.List()
+}
+
+// mayOverlap reports whether two SidecarGo may match the same pods.
+// It only rules out overlaps that are obvious from the namespaces and matchLabels.
+func mayOverlap(aObj, bObj SidecarGoObject) bool {
+	aNamespace, bNamespace := InjectedNamespace(aObj), InjectedNamespace(bObj)
+	if aNamespace != "" && bNamespace != "" && aNamespace != bNamespace {
+		return false
+	}
+	a, b := aObj.GetSpec(), bObj.GetSpec()
+	if a.Selector != nil && b.Selector != nil {
+		for key, value := range a.Selector.MatchLabels {
+			if other, ok := b.Selector.MatchLabels[key]; ok && other != value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// InjectedNamespace returns the only namespace the SidecarGo injects, or "" if it is not restricted to one.
+func InjectedNamespace(obj SidecarGoObject) string {
+	if obj.GetNamespace() != "" {
+		return obj.GetNamespace()
+	}
+	return obj.GetSpec().Namespace
+}
@@ -0,0 +1,340 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestValidateStrict(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		wantPath string
+	}{
+		{
+			name: "known fields",
+			raw:  `{"spec":{"containers":[{"name":"proxy","image":"proxy:v1","env":[{"name":"A","value":"1"}]}],"volumes":[{"name":"data","emptyDir":{}}]}}`,
+		},
+		{
+			name:     "unknown container field",
+			raw:      `{"spec":{"containers":[{"name":"proxy","image":"proxy:v1","imagePullPolicy":"Always","enviroment":[]}]}}`,
+			wantPath: "spec.containers",
+		},
+		{
+			name:     "unknown init container field",
+			raw:      `{"spec":{"initContainers":[{"name":"init","image":"init:v1","cmd":["sh"]}]}}`,
+			wantPath: "spec.initContainers",
+		},
+		{
+			name:     "unknown volume field",
+			raw:      `{"spec":{"volumes":[{"name":"data","emptyDirectory":{}}]}}`,
+			wantPath: "spec.volumes",
+		},
+		{
+			name:     "unknown container patch field",
+			raw:      `{"spec":{"containerPatches":[{"containers":["*"],"envs":[]}]}}`,
+			wantPath: "spec.containerPatches",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateStrict([]byte(tt.raw))
+			if tt.wantPath == "" {
+				if len(errs) > 0 {
+					t.Errorf("validateStrict() = %v, want no error", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || errs[0].Field != tt.wantPath {
+				t.Errorf("validateStrict() = %v, want an error at %s", errs, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestValidateSidecarGoSpec(t *testing.T) {
+	tests := []struct {
+		name string
+		spec SidecarGoSpec
+		// want are the fields of the expected errors.
+		want []string
+	}{
+		{
+			name: "valid",
+			spec: SidecarGoSpec{
+				Selector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				InitContainers: []corev1.Container{{Name: "init", Image: "init:v1"}},
+				Containers:     []corev1.Container{{Name: "proxy", Image: "proxy:v1"}},
+				Volumes:        []corev1.Volume{{Name: "data"}},
+			},
+		},
+		{
+			name: "duplicate container names",
+			spec: SidecarGoSpec{
+				InitContainers: []corev1.Container{{Name: "proxy", Image: "init:v1"}},
+				Containers:     []corev1.Container{{Name: "proxy", Image: "proxy:v1"}, {Name: "proxy", Image: "proxy:v2"}},
+			},
+			want: []string{"spec.containers[0].name", "spec.containers[1].name"},
+		},
+		{
+			name: "duplicate volume names",
+			spec: SidecarGoSpec{
+				Volumes: []corev1.Volume{{Name: "data"}, {Name: "data"}},
+			},
+			want: []string{"spec.volumes[1].name"},
+		},
+		{
+			name: "invalid names and missing image",
+			spec: SidecarGoSpec{
+				Containers: []corev1.Container{{Name: "Proxy", Image: "proxy:v1"}, {Image: "proxy:v1"}, {Name: "agent"}},
+			},
+			want: []string{"spec.containers[0].name", "spec.containers[1].name", "spec.containers[2].image"},
+		},
+		{
+			name: "templated names are checked once rendered",
+			spec: SidecarGoSpec{
+				Templated:  true,
+				Containers: []corev1.Container{{Name: "{{ .Name }}-proxy", Image: "proxy:v1"}},
+			},
+		},
+		{
+			name: "invalid selectors and patterns",
+			spec: SidecarGoSpec{
+				Selector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: metav1.LabelSelectorOpIn}},
+				},
+				ContainerSelector: &ContainerSelector{Images: []string{"nginx:[1"}},
+				ContainerPatches:  []ContainerPatch{{Containers: []string{"app", "[a"}}},
+			},
+			want: []string{"spec.selector", "spec.containerSelector.images[0]", "spec.containerPatches[0].containers[1]"},
+		},
+		{
+			name: "invalid node selector",
+			spec: SidecarGoSpec{
+				NodeSelector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "disk", Operator: "Matches"}},
+				},
+			},
+			want: []string{"spec.nodeSelector"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := ValidateSidecarGoSpec(&tt.spec, field.NewPath("spec"))
+			got := make([]string, 0, len(errs))
+			for _, err := range errs {
+				got = append(got, err.Field)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("ValidateSidecarGoSpec() = %v, want errors at %v", errs, tt.want)
+			}
+		})
+	}
+}
+
+func TestConflictingContainers(t *testing.T) {
+	sidecarGo := func(namespace string, matchLabels map[string]string, containers ...string) *SidecarGo {
+		obj := &SidecarGo{ObjectMeta: metav1.ObjectMeta{Name: "sidecargo", Namespace: namespace}}
+		if matchLabels != nil {
+			obj.Spec.Selector = &metav1.LabelSelector{MatchLabels: matchLabels}
+		}
+		for _, name := range containers {
+			obj.Spec.Containers = append(obj.Spec.Containers, corev1.Container{Name: name})
+		}
+		return obj
+	}
+	clusterSidecarGo := func(namespace string, containers ...string) *ClusterSidecarGo {
+		obj := &ClusterSidecarGo{ObjectMeta: metav1.ObjectMeta{Name: "cluster"}}
+		obj.Spec.Namespace = namespace
+		for _, name := range containers {
+			obj.Spec.InitContainers = append(obj.Spec.InitContainers, corev1.Container{Name: name})
+		}
+		return obj
+	}
+
+	tests := []struct {
+		name string
+		a, b SidecarGoObject
+		want []string
+	}{
+		{
+			name: "same namespace and labels",
+			a:    sidecarGo("default", map[string]string{"app": "web"}, "proxy", "agent"),
+			b:    sidecarGo("default", map[string]string{"app": "web"}, "agent", "proxy", "tracer"),
+			want: []string{"agent", "proxy"},
+		},
+		{
+			name: "no common container",
+			a:    sidecarGo("default", nil, "proxy"),
+			b:    sidecarGo("default", nil, "agent"),
+		},
+		{
+			name: "different namespaces",
+			a:    sidecarGo("default", nil, "proxy"),
+			b:    sidecarGo("prod", nil, "proxy"),
+		},
+		{
+			name: "different values of the same label",
+			a:    sidecarGo("default", map[string]string{"app": "web"}, "proxy"),
+			b:    sidecarGo("default", map[string]string{"app": "db"}, "proxy"),
+		},
+		{
+			name: "different labels may overlap",
+			a:    sidecarGo("default", map[string]string{"app": "web"}, "proxy"),
+			b:    sidecarGo("default", map[string]string{"tier": "frontend"}, "proxy"),
+			want: []string{"proxy"},
+		},
+		{
+			name: "cluster SidecarGo of all namespaces, init containers included",
+			a:    sidecarGo("default", map[string]string{"app": "web"}, "proxy"),
+			b:    clusterSidecarGo("", "proxy"),
+			want: []string{"proxy"},
+		},
+		{
+			name: "cluster SidecarGo of another namespace",
+			a:    sidecarGo("default", map[string]string{"app": "web"}, "proxy"),
+			b:    clusterSidecarGo("prod", "proxy"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ConflictingContainers(tt.a, tt.b)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("ConflictingContainers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSidecarGoValidateHandle(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing := &SidecarGo{
+		ObjectMeta: metav1.ObjectMeta{Name: "proxy", Namespace: "default"},
+		Spec: SidecarGoSpec{
+			Selector:   &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			Containers: []corev1.Container{{Name: "proxy", Image: "proxy:v1"}},
+		},
+	}
+	newSidecarGo := func(name string, mutate func(*SidecarGo)) *SidecarGo {
+		obj := &SidecarGo{
+			TypeMeta:   metav1.TypeMeta{APIVersion: GroupVersion.String(), Kind: "SidecarGo"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: SidecarGoSpec{
+				Selector:   &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				Containers: []corev1.Container{{Name: "proxy", Image: "proxy:v2"}},
+			},
+		}
+		if mutate != nil {
+			mutate(obj)
+		}
+		return obj
+	}
+
+	tests := []struct {
+		name    string
+		obj     *SidecarGo
+		allowed bool
+		// reason is part of the message of a denied request.
+		reason string
+	}{
+		{
+			name:   "conflicts with an existing SidecarGo",
+			obj:    newSidecarGo("other", nil),
+			reason: "also injected by SidecarGo default/proxy",
+		},
+		{
+			name:    "update of the existing SidecarGo",
+			obj:     newSidecarGo("proxy", nil),
+			allowed: true,
+		},
+		{
+			name: "other pods",
+			obj: newSidecarGo("other", func(obj *SidecarGo) {
+				obj.Spec.Selector.MatchLabels["app"] = "db"
+			}),
+			allowed: true,
+		},
+		{
+			name: "other class",
+			obj: newSidecarGo("other", func(obj *SidecarGo) {
+				obj.Spec.Class = "security"
+				obj.Labels = map[string]string{LabelClass: "security"}
+			}),
+			allowed: true,
+		},
+		{
+			name: "class without label",
+			obj: newSidecarGo("other", func(obj *SidecarGo) {
+				obj.Spec.Class = "security"
+				obj.Spec.Containers[0].Name = "agent"
+			}),
+			reason: LabelClass,
+		},
+		{
+			name: "namespace selector of a namespaced SidecarGo",
+			obj: newSidecarGo("other", func(obj *SidecarGo) {
+				obj.Spec.NamespaceSelector = &metav1.LabelSelector{}
+				obj.Spec.Containers[0].Name = "agent"
+			}),
+			reason: "spec.namespaceSelector",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sv := NewSidecarGoValidate(fake.NewClientBuilder().WithScheme(scheme).WithObjects(existing).Build())
+			if err := sv.(admission.DecoderInjector).InjectDecoder(decoder); err != nil {
+				t.Fatal(err)
+			}
+			raw, err := json.Marshal(tt.obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := sv.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Kind:      metav1.GroupVersionKind{Group: GroupVersion.Group, Version: GroupVersion.Version, Kind: "SidecarGo"},
+				Name:      tt.obj.Name,
+				Namespace: tt.obj.Namespace,
+				Object:    runtime.RawExtension{Raw: raw},
+			}})
+			if resp.Allowed != tt.allowed {
+				t.Errorf("Handle() allowed = %v, want %v: %s", resp.Allowed, tt.allowed, resp.Result.Reason)
+			}
+			if !strings.Contains(string(resp.Result.Reason), tt.reason) {
+				t.Errorf("Handle() reason = %q, want %q", resp.Result.Reason, tt.reason)
+			}
+		})
+	}
+}
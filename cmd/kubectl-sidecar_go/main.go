@@ -0,0 +1,193 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-sidecar_go is a kubectl plugin previewing what sidecar-go would inject into a pod:
+//
+//	kubectl sidecar-go dry-run -f pod.yaml
+//
+// It posts the pod to the dry-run endpoint of the webhook server through the API server service proxy,
+// so it needs the get and create verbs on services/proxy in the namespace of sidecar-go.
+// No credential is forwarded: the plugin looks up the user of the kubeconfig with a SelfSubjectReview
+// and the webhook server dry runs as that user, which requires the create verb on pods in the namespace of the pod.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	v1 "github.com/togettoyou/sidecar-go/api/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+)
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "dry-run" {
+		fmt.Fprintln(os.Stderr, "usage: kubectl sidecar-go dry-run -f FILE [flags]")
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("dry-run", flag.ExitOnError)
+	var filename, namespace, serviceName, serviceNamespace, output, kubeconfig string
+	fs.StringVar(&filename, "f", "", "The pod manifest to dry run, - for stdin.")
+	fs.StringVar(&namespace, "n", "", "The namespace of the pod if the manifest has none, defaults to the namespace of the current context.")
+	fs.StringVar(&serviceName, "service", "sidecar-go-service", "The Service of the sidecar-go webhook server.")
+	fs.StringVar(&serviceNamespace, "service-namespace", "sidecar-go-system", "The namespace of the sidecar-go webhook Service.")
+	fs.StringVar(&output, "o", "text", "The output format: text, json or yaml.")
+	fs.StringVar(&kubeconfig, "kubeconfig", "", "The kubeconfig file, defaults to the kubectl one.")
+	_ = fs.Parse(os.Args[2:])
+
+	if err := dryRun(filename, namespace, serviceName, serviceNamespace, output, kubeconfig); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func dryRun(filename, namespace, serviceName, serviceNamespace, output, kubeconfig string) error {
+	if filename == "" {
+		return fmt.Errorf("-f is required")
+	}
+	pod, err := readFile(filename)
+	if err != nil {
+		return err
+	}
+
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubeconfig
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{})
+	if namespace == "" {
+		namespace, _, err = clientConfig.Namespace()
+		if err != nil {
+			return err
+		}
+	}
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	user, err := currentUser(clientset)
+	if err != nil {
+		return err
+	}
+
+	data, err := clientset.CoreV1().RESTClient().Post().
+		Namespace(serviceNamespace).
+		Resource("services").
+		Name("https:"+serviceName+":443").
+		SubResource("proxy").
+		Suffix(v1.DryRunPath).
+		Param("namespace", namespace).
+		SetHeader(v1.DryRunUserHeader, user.Username).
+		SetHeader(v1.DryRunGroupHeader, user.Groups...).
+		Body(pod).
+		DoRaw(context.Background())
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, data)
+	}
+	result := &v1.DryRunResult{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return err
+	}
+	return printResult(os.Stdout, result, output)
+}
+
+// currentUser returns the user the kubeconfig authenticates as.
+// The SelfSubjectReview is newer than the vendored client, so it is posted raw.
+func currentUser(clientset kubernetes.Interface) (*authenticationv1.UserInfo, error) {
+	data, err := clientset.AuthenticationV1().RESTClient().Post().
+		Resource("selfsubjectreviews").
+		Body([]byte(`{"apiVersion":"authentication.k8s.io/v1","kind":"SelfSubjectReview"}`)).
+		DoRaw(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("unable to review the user of the kubeconfig: %w", err)
+	}
+	review := &struct {
+		Status struct {
+			UserInfo authenticationv1.UserInfo `json:"userInfo"`
+		} `json:"status"`
+	}{}
+	if err := json.Unmarshal(data, review); err != nil {
+		return nil, err
+	}
+	return &review.Status.UserInfo, nil
+}
+
+func readFile(filename string) ([]byte, error) {
+	if filename == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(filename)
+}
+
+func printResult(w io.Writer, result *v1.DryRunResult, output string) error {
+	switch output {
+	case "json":
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case "yaml":
+		data, err := yaml.Marshal(result)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case "text":
+	default:
+		return fmt.Errorf("unknown output format %q", output)
+	}
+
+	b := &strings.Builder{}
+	fmt.Fprintln(b, "Injected:")
+	for _, name := range result.Injected {
+		fmt.Fprintf(b, "  %s\n", name)
+	}
+	fmt.Fprintln(b, "Skipped:")
+	names := make([]string, 0, len(result.Skipped))
+	for name := range result.Skipped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(b, "  %s\t%s\n", name, result.Skipped[name])
+	}
+	if result.Denied != "" {
+		fmt.Fprintf(b, "Denied: %s\n", result.Denied)
+	}
+	if len(result.Patch) > 0 {
+		patch, err := json.MarshalIndent(result.Patch, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, "Patch:\n%s\n", patch)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	appsv1alpha1 "github.com/togettoyou/sidecar-go/api/v1alpha1"
+)
+
+// ClusterSidecarGoReconciler reconciles a ClusterSidecarGo object the same way as a SidecarGo
+type ClusterSidecarGoReconciler struct {
+	SidecarGoReconciler
+}
+
+//+kubebuilder:rbac:groups=apps.togettoyou.com,resources=clustersidecargoes,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps.togettoyou.com,resources=clustersidecargoes/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=apps.togettoyou.com,resources=clustersidecargoes/finalizers,verbs=update
+
+// Reconcile loads the ClusterSidecarGo for the pod webhook and reports the pods it matches.
+func (r *ClusterSidecarGoReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	return r.reconcile(ctx, req, &appsv1alpha1.ClusterSidecarGo{})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterSidecarGoReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&appsv1alpha1.ClusterSidecarGo{}).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, handler.EnqueueRequestsFromMapFunc(r.podToSidecarGo(true))).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(r.namespaceToSidecarGo(true)),
+			builder.WithPredicates(predicate.LabelChangedPredicate{})).
+		Complete(r)
+}
@@ -18,14 +18,28 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	"github.com/togettoyou/sidecar-go/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	appsv1alpha1 "github.com/togettoyou/sidecar-go/api/v1alpha1"
 )
@@ -34,12 +48,25 @@ import (
 type SidecarGoReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Class is the injector class of this installation. SidecarGo of other classes
+	// are left to their own installation.
+	Class    string
+	Recorder record.EventRecorder
+	// Store holds the SidecarGo injected by the pod webhook. It follows the SidecarGo informer by itself,
+	// the reconciler only reads it to count the matched pods.
+	Store *util.SpecStore
 }
 
 //+kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=validatingwebhookconfigurations,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps.togettoyou.com,resources=sidecargoes,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps.togettoyou.com,resources=sidecargoes/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=apps.togettoyou.com,resources=sidecargoes/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -51,25 +78,229 @@ type SidecarGoReconciler struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.12.2/pkg/reconcile
 func (r *SidecarGoReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	return r.reconcile(ctx, req, &appsv1alpha1.SidecarGo{})
+}
+
+// reconcile reconciles the SidecarGo or ClusterSidecarGo of the request into sidecarGo.
+func (r *SidecarGoReconciler) reconcile(ctx context.Context, req ctrl.Request, sidecarGo appsv1alpha1.SidecarGoObject) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
 
-	sidecarGo := &appsv1alpha1.SidecarGo{}
 	err := r.Get(ctx, req.NamespacedName, sidecarGo)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			logger.Info("SidecarGo delete")
-			return ctrl.Result{}, util.UpdateSidecarGoSpec(req.NamespacedName.String(), nil)
+			return ctrl.Result{}, nil
 		}
 		return ctrl.Result{}, err
 	}
 
+	spec := sidecarGo.GetSpec()
+	if spec.Class != r.Class {
+		logger.Info("SidecarGo of another class", "class", spec.Class)
+		return ctrl.Result{}, nil
+	}
+
 	logger.Info("SidecarGo apply")
-	return ctrl.Result{}, util.UpdateSidecarGoSpec(req.NamespacedName.String(), &sidecarGo.Spec)
+	// spec problems are recorded once per generation, rather than on every pod event
+	newGeneration := sidecarGo.GetStatus().ObservedGeneration != sidecarGo.GetGeneration()
+	status := appsv1alpha1.SidecarGoStatus{
+		ObservedGeneration: sidecarGo.GetGeneration(),
+		Conditions:         append([]metav1.Condition(nil), sidecarGo.GetStatus().Conditions...),
+	}
+	if err := util.ValidateSpec(spec); err != nil {
+		logger.Error(err, "SidecarGo spec invalid")
+		if newGeneration {
+			r.Recorder.Event(sidecarGo, corev1.EventTypeWarning, util.EventReasonInvalidSpec, err.Error())
+		}
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               appsv1alpha1.ConditionReady,
+			Status:             metav1.ConditionFalse,
+			Reason:             "InvalidSpec",
+			Message:            err.Error(),
+			ObservedGeneration: sidecarGo.GetGeneration(),
+		})
+		meta.RemoveStatusCondition(&status.Conditions, appsv1alpha1.ConditionDegraded)
+		return ctrl.Result{}, r.updateStatus(ctx, sidecarGo, status)
+	}
+
+	if newGeneration {
+		if err := r.recordConflicts(ctx, sidecarGo); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	pending, err := r.countPods(ctx, sidecarGo, &status)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	result, err := r.rollout(ctx, sidecarGo, pending, &status)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               appsv1alpha1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "SpecLoaded",
+		Message:            "SidecarGo is used by the pod webhook",
+		ObservedGeneration: sidecarGo.GetGeneration(),
+	})
+	degraded := metav1.Condition{
+		Type:               appsv1alpha1.ConditionDegraded,
+		Status:             metav1.ConditionFalse,
+		Reason:             "PodsInjected",
+		Message:            "All matched pods carry the current sidecars",
+		ObservedGeneration: sidecarGo.GetGeneration(),
+	}
+	if notInjected := status.MatchedPods - status.SkippedPods - status.InjectedPods; notInjected > 0 {
+		degraded.Status = metav1.ConditionTrue
+		degraded.Reason = "PodsNotInjected"
+		degraded.Message = fmt.Sprintf("%d matched pods are missing sidecars", notInjected)
+	} else if status.OutdatedPods > 0 {
+		degraded.Status = metav1.ConditionTrue
+		degraded.Reason = "PodsOutdated"
+		degraded.Message = fmt.Sprintf("%d matched pods run outdated sidecars", status.OutdatedPods)
+	}
+	meta.SetStatusCondition(&status.Conditions, degraded)
+
+	return result, r.updateStatus(ctx, sidecarGo, status)
+}
+
+// recordConflicts records a warning event for every SidecarGo or ClusterSidecarGo of the same class
+// injecting containers of the same name into the same pods.
+func (r *SidecarGoReconciler) recordConflicts(ctx context.Context, sidecarGo appsv1alpha1.SidecarGoObject) error {
+	others, err := appsv1alpha1.ListSidecarGoObjects(ctx, r.Client)
+	if err != nil {
+		return err
+	}
+	for _, other := range others {
+		if other.GetUID() == sidecarGo.GetUID() || other.GetSpec().Class != sidecarGo.GetSpec().Class {
+			continue
+		}
+		if names := appsv1alpha1.ConflictingContainers(sidecarGo, other); len(names) > 0 {
+			r.Recorder.Eventf(sidecarGo, corev1.EventTypeWarning, util.EventReasonContainerConflict,
+				"containers %s are also injected by %s %s", strings.Join(names, ", "),
+				appsv1alpha1.KindOf(other), appsv1alpha1.KeyOf(other))
+		}
+	}
+	return nil
+}
+
+// countPods counts the pods matching the SidecarGo into status,
+// and returns the matched pods that miss the current sidecars.
+func (r *SidecarGoReconciler) countPods(ctx context.Context, sidecarGo appsv1alpha1.SidecarGoObject, status *appsv1alpha1.SidecarGoStatus) ([]*corev1.Pod, error) {
+	pods := &corev1.PodList{}
+	var opts []client.ListOption
+	if namespace := appsv1alpha1.InjectedNamespace(sidecarGo); namespace != "" {
+		opts = append(opts, client.InNamespace(namespace))
+	}
+	if err := r.List(ctx, pods, opts...); err != nil {
+		return nil, err
+	}
+
+	revision, err := util.SpecRevision(sidecarGo.GetSpec())
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := &corev1.NamespaceList{}
+	if err := r.List(ctx, namespaces); err != nil {
+		return nil, err
+	}
+	namespaceM := make(map[string]*corev1.Namespace, len(namespaces.Items))
+	for i := range namespaces.Items {
+		namespaceM[namespaces.Items[i].Name] = &namespaces.Items[i]
+	}
+
+	pending := make([]*corev1.Pod, 0)
+	namespacedName := appsv1alpha1.KeyOf(sidecarGo)
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		namespace, ok := namespaceM[pod.Namespace]
+		if !ok || !isPodActive(pod) || !r.Store.PodMatches(ctx, namespacedName, pod, namespace) {
+			continue
+		}
+		status.MatchedPods++
+		if util.SkipReason(namespacedName, pod) != "" {
+			// pods opting out are neither injected nor restarted
+			status.SkippedPods++
+			continue
+		}
+		injected, upToDate := util.PodInjectedState(namespacedName, revision, sidecarGo.GetSpec(), pod)
+		if injected {
+			status.InjectedPods++
+			if !upToDate {
+				status.OutdatedPods++
+			}
+		}
+		if !injected || !upToDate {
+			pending = append(pending, pod)
+		}
+	}
+	return pending, nil
+}
+
+// updateStatus writes status to the SidecarGo if it changed.
+func (r *SidecarGoReconciler) updateStatus(ctx context.Context, sidecarGo appsv1alpha1.SidecarGoObject, status appsv1alpha1.SidecarGoStatus) error {
+	if equality.Semantic.DeepEqual(status, *sidecarGo.GetStatus()) {
+		return nil
+	}
+	*sidecarGo.GetStatus() = status
+	return r.Status().Update(ctx, sidecarGo)
+}
+
+// podToSidecarGo maps a pod event to the SidecarGo objects matching the pod,
+// or to the ClusterSidecarGo objects if cluster is set.
+func (r *SidecarGoReconciler) podToSidecarGo(cluster bool) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			return nil
+		}
+		namespace := &corev1.Namespace{}
+		if err := r.Get(context.Background(), types.NamespacedName{Name: pod.Namespace}, namespace); err != nil {
+			return nil
+		}
+		return toRequests(r.Store.PodMatchedNames(context.Background(), pod, namespace), cluster)
+	}
+}
+
+// namespaceToSidecarGo maps a namespace event to all SidecarGo objects, or all ClusterSidecarGo objects
+// if cluster is set, as changing namespace labels may change the pods matched by a namespace selector.
+func (r *SidecarGoReconciler) namespaceToSidecarGo(cluster bool) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		return toRequests(r.Store.Names(), cluster)
+	}
+}
+
+// toRequests returns the requests of the SidecarGo keys of the store,
+// or of the ClusterSidecarGo keys if cluster is set.
+func toRequests(namespacedNames []string, cluster bool) []reconcile.Request {
+	requests := make([]reconcile.Request, 0, len(namespacedNames))
+	for _, namespacedName := range namespacedNames {
+		namespace, name, err := cache.SplitMetaNamespaceKey(namespacedName)
+		if err != nil || (namespace == "") != cluster {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: namespace, Name: name},
+		})
+	}
+	return requests
+}
+
+func isPodActive(pod *corev1.Pod) bool {
+	return pod.DeletionTimestamp == nil &&
+		pod.Status.Phase != corev1.PodSucceeded &&
+		pod.Status.Phase != corev1.PodFailed
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *SidecarGoReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&appsv1alpha1.SidecarGo{}).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, handler.EnqueueRequestsFromMapFunc(r.podToSidecarGo(false))).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(r.namespaceToSidecarGo(false)),
+			builder.WithPredicates(predicate.LabelChangedPredicate{})).
 		Complete(r)
 }
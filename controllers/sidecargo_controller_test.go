@@ -0,0 +1,88 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/togettoyou/sidecar-go/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appsv1alpha1 "github.com/togettoyou/sidecar-go/api/v1alpha1"
+)
+
+func TestCountPods(t *testing.T) {
+	sidecarGo := &appsv1alpha1.ClusterSidecarGo{
+		ObjectMeta: metav1.ObjectMeta{Name: "proxy"},
+		Spec: appsv1alpha1.SidecarGoSpec{
+			Containers: []corev1.Container{{Name: "proxy", Image: "proxy:v1"}},
+			// matches the pods of all namespaces
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "kubernetes.io/metadata.name", Operator: metav1.LabelSelectorOpExists},
+				},
+			},
+		},
+	}
+	store := util.NewSpecStore(nil, nil, "")
+	store.ExcludedNamespaces = sets.NewString("kube-system", "sidecar-go-system")
+	if err := store.Update(appsv1alpha1.KeyOf(sidecarGo), &sidecarGo.Spec); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	namespace := func(name string) *corev1.Namespace {
+		return &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"kubernetes.io/metadata.name": name}},
+		}
+	}
+	pod := func(namespace, name string, annotations map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Annotations: annotations},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+	objs := []client.Object{
+		namespace("default"), namespace("kube-system"), namespace("sidecar-go-system"),
+		pod("default", "web", nil),
+		pod("default", "opted-out", map[string]string{util.AnnotationSkip: "proxy"}),
+		pod("kube-system", "coredns", nil),
+		pod("sidecar-go-system", "manager", nil),
+	}
+	r := &SidecarGoReconciler{
+		Client: fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(objs...).Build(),
+		Store:  store,
+	}
+
+	status := &appsv1alpha1.SidecarGoStatus{}
+	pending, err := r.countPods(context.Background(), sidecarGo, status)
+	if err != nil {
+		t.Fatalf("countPods() error = %v", err)
+	}
+	want := appsv1alpha1.SidecarGoStatus{MatchedPods: 2, SkippedPods: 1}
+	if status.MatchedPods != want.MatchedPods || status.SkippedPods != want.SkippedPods || status.InjectedPods != want.InjectedPods {
+		t.Errorf("countPods() status = %+v, want %+v", *status, want)
+	}
+	if len(pending) != 1 || pending[0].Name != "web" {
+		t.Errorf("countPods() pending = %v, want only web", pending)
+	}
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/togettoyou/sidecar-go/pkg/util"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1alpha1 "github.com/togettoyou/sidecar-go/api/v1alpha1"
+)
+
+// defaultProgressDeadline is the progress deadline of rollouts that do not set one.
+const defaultProgressDeadline = 600 * time.Second
+
+// workload is a Deployment, StatefulSet or DaemonSet owning matched pods.
+type workload struct {
+	kind string
+	key  types.NamespacedName
+}
+
+// rollout restarts the workloads owning pending pods in batches and records the progress in status.
+func (r *SidecarGoReconciler) rollout(ctx context.Context, sidecarGo appsv1alpha1.SidecarGoObject, pending []*corev1.Pod, status *appsv1alpha1.SidecarGoStatus) (ctrl.Result, error) {
+	spec := sidecarGo.GetSpec().Rollout
+	if spec == nil || !spec.Enabled {
+		return ctrl.Result{}, nil
+	}
+	logger := log.FromContext(ctx)
+
+	rolloutStatus := &appsv1alpha1.RolloutStatus{}
+	if last := sidecarGo.GetStatus().Rollout; last != nil {
+		rolloutStatus.LastBatchTime = last.LastBatchTime
+	}
+	status.Rollout = rolloutStatus
+
+	workloads, err := r.pendingWorkloads(ctx, pending)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	namespacedName := appsv1alpha1.KeyOf(sidecarGo)
+	revision, err := util.SpecRevision(sidecarGo.GetSpec())
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	deadline := time.Duration(spec.ProgressDeadlineSeconds) * time.Second
+	if deadline <= 0 {
+		deadline = defaultProgressDeadline
+	}
+	// nextDeadline is when the first updating workload stalls
+	var nextDeadline time.Duration
+	toRestart := make([]client.Object, 0)
+	for _, w := range workloads {
+		obj, err := r.getWorkload(ctx, w)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return ctrl.Result{}, err
+		}
+		// restarting workloads updated on delete only would never free their slot
+		if onDelete(obj) {
+			rolloutStatus.SkippedWorkloads++
+			continue
+		}
+		template := workloadTemplate(obj)
+		revisions := util.ParseRevisions(template.Annotations[util.AnnotationRollout])
+		if revisions[namespacedName] == revision {
+			// a restart time that can not be parsed gets the full deadline
+			left := deadline
+			if restartedAt, err := time.Parse(time.RFC3339, template.Annotations[util.AnnotationRestartedAt]); err == nil {
+				left = time.Until(restartedAt.Add(deadline))
+			}
+			if left <= 0 {
+				rolloutStatus.StalledWorkloads++
+				continue
+			}
+			rolloutStatus.UpdatingWorkloads++
+			if nextDeadline == 0 || left < nextDeadline {
+				nextDeadline = left
+			}
+			continue
+		}
+		rolloutStatus.PendingWorkloads++
+		toRestart = append(toRestart, obj)
+	}
+	if len(toRestart) == 0 {
+		return ctrl.Result{}, nil
+	}
+
+	pause := time.Duration(spec.PauseSeconds) * time.Second
+	if last := rolloutStatus.LastBatchTime; last != nil {
+		if wait := time.Until(last.Add(pause)); wait > 0 {
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+	}
+	batchSize := spec.BatchSize
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	slots := int(batchSize - rolloutStatus.UpdatingWorkloads)
+	if slots <= 0 {
+		// wait for the pods of the current batch to be replaced, or to stall
+		return ctrl.Result{RequeueAfter: nextDeadline}, nil
+	}
+	if slots > len(toRestart) {
+		slots = len(toRestart)
+	}
+
+	now := metav1.Now()
+	for _, obj := range toRestart[:slots] {
+		logger.Info("SidecarGo rollout restart", "kind", obj.GetObjectKind().GroupVersionKind().Kind,
+			"workload", client.ObjectKeyFromObject(obj))
+		if err := r.restartWorkload(ctx, obj, namespacedName, revision, now); err != nil {
+			return ctrl.Result{}, err
+		}
+		rolloutStatus.PendingWorkloads--
+		rolloutStatus.UpdatingWorkloads++
+	}
+	rolloutStatus.LastBatchTime = &now
+
+	if rolloutStatus.PendingWorkloads > 0 {
+		return ctrl.Result{RequeueAfter: pause}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// pendingWorkloads resolves the owning workloads of the pods, sorted for a stable restart order.
+func (r *SidecarGoReconciler) pendingWorkloads(ctx context.Context, pods []*corev1.Pod) ([]workload, error) {
+	seen := make(map[workload]bool)
+	workloads := make([]workload, 0)
+	for _, pod := range pods {
+		owner := metav1.GetControllerOf(pod)
+		if owner == nil {
+			continue
+		}
+		w := workload{kind: owner.Kind, key: types.NamespacedName{Namespace: pod.Namespace, Name: owner.Name}}
+		if owner.Kind == "ReplicaSet" {
+			rs := &appsv1.ReplicaSet{}
+			if err := r.Get(ctx, w.key, rs); err != nil {
+				if errors.IsNotFound(err) {
+					continue
+				}
+				return nil, err
+			}
+			rsOwner := metav1.GetControllerOf(rs)
+			if rsOwner == nil {
+				continue
+			}
+			w = workload{kind: rsOwner.Kind, key: types.NamespacedName{Namespace: rs.Namespace, Name: rsOwner.Name}}
+		}
+		switch w.kind {
+		case "Deployment", "StatefulSet", "DaemonSet":
+		default:
+			continue
+		}
+		if !seen[w] {
+			seen[w] = true
+			workloads = append(workloads, w)
+		}
+	}
+	sort.Slice(workloads, func(i, j int) bool {
+		if workloads[i].kind != workloads[j].kind {
+			return workloads[i].kind < workloads[j].kind
+		}
+		return workloads[i].key.String() < workloads[j].key.String()
+	})
+	return workloads, nil
+}
+
+func (r *SidecarGoReconciler) getWorkload(ctx context.Context, w workload) (client.Object, error) {
+	var obj client.Object
+	switch w.kind {
+	case "Deployment":
+		obj = &appsv1.Deployment{}
+	case "StatefulSet":
+		obj = &appsv1.StatefulSet{}
+	default:
+		obj = &appsv1.DaemonSet{}
+	}
+	if err := r.Get(ctx, w.key, obj); err != nil {
+		return nil, err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(appsv1.SchemeGroupVersion.WithKind(w.kind))
+	return obj, nil
+}
+
+// restartWorkload bumps the pod template annotations of the workload, like `kubectl rollout restart`.
+func (r *SidecarGoReconciler) restartWorkload(ctx context.Context, obj client.Object, namespacedName, revision string, now metav1.Time) error {
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	template := workloadTemplate(obj)
+	if template.Annotations == nil {
+		template.Annotations = make(map[string]string)
+	}
+	revisions := util.ParseRevisions(template.Annotations[util.AnnotationRollout])
+	revisions[namespacedName] = revision
+	template.Annotations[util.AnnotationRollout] = util.FormatRevisions(revisions)
+	template.Annotations[util.AnnotationRestartedAt] = now.Format(time.RFC3339)
+	return r.Patch(ctx, obj, patch)
+}
+
+// onDelete reports whether the pods of the workload are only updated when deleted.
+func onDelete(obj client.Object) bool {
+	switch o := obj.(type) {
+	case *appsv1.StatefulSet:
+		return o.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType
+	case *appsv1.DaemonSet:
+		return o.Spec.UpdateStrategy.Type == appsv1.OnDeleteDaemonSetStrategyType
+	}
+	return false
+}
+
+func workloadTemplate(obj client.Object) *corev1.PodTemplateSpec {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		return &o.Spec.Template
+	case *appsv1.StatefulSet:
+		return &o.Spec.Template
+	case *appsv1.DaemonSet:
+		return &o.Spec.Template
+	}
+	return &corev1.PodTemplateSpec{}
+}
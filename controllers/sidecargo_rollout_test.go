@@ -0,0 +1,291 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/togettoyou/sidecar-go/pkg/util"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appsv1alpha1 "github.com/togettoyou/sidecar-go/api/v1alpha1"
+)
+
+func TestRollout(t *testing.T) {
+	rolloutSpec := func(batchSize, pauseSeconds int32) *appsv1alpha1.RolloutSpec {
+		return &appsv1alpha1.RolloutSpec{Enabled: true, BatchSize: batchSize, PauseSeconds: pauseSeconds, ProgressDeadlineSeconds: 600}
+	}
+	deadline := 600 * time.Second
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		rollout *appsv1alpha1.RolloutSpec
+		// lastBatch is how long ago the last batch was restarted, if any.
+		lastBatch time.Duration
+		workloads []client.Object
+		// pending are the names of the StatefulSets owning pending pods.
+		pending       []string
+		wantRestarted []string
+		wantStatus    *appsv1alpha1.RolloutStatus
+		// wantRequeue is the expected RequeueAfter, within a second.
+		wantRequeue time.Duration
+	}{
+		{
+			name:    "disabled",
+			rollout: &appsv1alpha1.RolloutSpec{},
+			workloads: []client.Object{
+				statefulSet("a", nil),
+			},
+			pending: []string{"a"},
+		},
+		{
+			name:    "first batch",
+			rollout: rolloutSpec(2, 30),
+			workloads: []client.Object{
+				statefulSet("a", nil), statefulSet("b", nil), statefulSet("c", nil),
+			},
+			pending:       []string{"c", "b", "a"},
+			wantRestarted: []string{"a", "b"},
+			wantStatus:    &appsv1alpha1.RolloutStatus{PendingWorkloads: 1, UpdatingWorkloads: 2},
+			wantRequeue:   30 * time.Second,
+		},
+		{
+			name:      "pause between batches",
+			rollout:   rolloutSpec(2, 30),
+			lastBatch: 10 * time.Second,
+			workloads: []client.Object{
+				statefulSet("a", nil),
+			},
+			pending:     []string{"a"},
+			wantStatus:  &appsv1alpha1.RolloutStatus{PendingWorkloads: 1},
+			wantRequeue: 20 * time.Second,
+		},
+		{
+			name:    "batch full until the first deadline",
+			rollout: rolloutSpec(2, 0),
+			workloads: []client.Object{
+				statefulSet("a", restarted(now.Add(-100*time.Second))),
+				statefulSet("b", restarted(now.Add(-200*time.Second))),
+				statefulSet("c", nil),
+			},
+			pending:     []string{"a", "b", "c"},
+			wantStatus:  &appsv1alpha1.RolloutStatus{PendingWorkloads: 1, UpdatingWorkloads: 2},
+			wantRequeue: deadline - 200*time.Second,
+		},
+		{
+			name:    "stalled workload frees its slot",
+			rollout: rolloutSpec(1, 0),
+			workloads: []client.Object{
+				statefulSet("a", restarted(now.Add(-deadline-time.Second))),
+				statefulSet("b", nil),
+			},
+			pending:       []string{"a", "b"},
+			wantRestarted: []string{"b"},
+			wantStatus:    &appsv1alpha1.RolloutStatus{UpdatingWorkloads: 1, StalledWorkloads: 1},
+		},
+		{
+			name:    "unparsable restart time gets the full deadline",
+			rollout: rolloutSpec(1, 0),
+			workloads: []client.Object{
+				statefulSet("a", map[string]string{util.AnnotationRestartedAt: "yesterday"}),
+				statefulSet("b", nil),
+			},
+			pending:     []string{"a", "b"},
+			wantStatus:  &appsv1alpha1.RolloutStatus{PendingWorkloads: 1, UpdatingWorkloads: 1},
+			wantRequeue: deadline,
+		},
+		{
+			name:    "OnDelete workloads are skipped",
+			rollout: rolloutSpec(1, 0),
+			workloads: []client.Object{
+				onDeleteStatefulSet("a"),
+				statefulSet("b", nil),
+			},
+			pending:       []string{"a", "b"},
+			wantRestarted: []string{"b"},
+			wantStatus:    &appsv1alpha1.RolloutStatus{UpdatingWorkloads: 1, SkippedWorkloads: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sidecarGo := &appsv1alpha1.SidecarGo{
+				ObjectMeta: metav1.ObjectMeta{Name: "proxy", Namespace: "default"},
+				Spec:       appsv1alpha1.SidecarGoSpec{Rollout: tt.rollout},
+			}
+			if tt.lastBatch != 0 {
+				lastBatch := metav1.NewTime(now.Add(-tt.lastBatch))
+				sidecarGo.Status.Rollout = &appsv1alpha1.RolloutStatus{LastBatchTime: &lastBatch}
+			}
+			revision, err := util.SpecRevision(&sidecarGo.Spec)
+			if err != nil {
+				t.Fatalf("SpecRevision() error = %v", err)
+			}
+			for _, obj := range tt.workloads {
+				annotations := obj.(*appsv1.StatefulSet).Spec.Template.Annotations
+				if _, ok := annotations[util.AnnotationRestartedAt]; ok {
+					annotations[util.AnnotationRollout] = util.FormatRevisions(map[string]string{"default/proxy": revision})
+				}
+			}
+			r := &SidecarGoReconciler{
+				Client: fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(tt.workloads...).Build(),
+			}
+			pending := make([]*corev1.Pod, 0, len(tt.pending))
+			for _, name := range tt.pending {
+				pending = append(pending, statefulSetPod(name))
+			}
+
+			status := &appsv1alpha1.SidecarGoStatus{}
+			result, err := r.rollout(context.Background(), sidecarGo, pending, status)
+			if err != nil {
+				t.Fatalf("rollout() error = %v", err)
+			}
+
+			if tt.wantStatus == nil {
+				if status.Rollout != nil {
+					t.Errorf("rollout() status = %+v, want none", status.Rollout)
+				}
+			} else {
+				got := *status.Rollout
+				got.LastBatchTime = nil
+				if got != *tt.wantStatus {
+					t.Errorf("rollout() status = %+v, want %+v", got, *tt.wantStatus)
+				}
+			}
+			if diff := result.RequeueAfter - tt.wantRequeue; diff < -time.Second || diff > time.Second {
+				t.Errorf("rollout() RequeueAfter = %v, want %v", result.RequeueAfter, tt.wantRequeue)
+			}
+
+			restarted := make([]string, 0)
+			for _, obj := range tt.workloads {
+				current := &appsv1.StatefulSet{}
+				if err := r.Get(context.Background(), client.ObjectKeyFromObject(obj), current); err != nil {
+					t.Fatalf("Get() error = %v", err)
+				}
+				if current.Spec.Template.Annotations[util.AnnotationRestartedAt] != obj.(*appsv1.StatefulSet).Spec.Template.Annotations[util.AnnotationRestartedAt] {
+					restarted = append(restarted, current.Name)
+				}
+			}
+			if len(restarted) != len(tt.wantRestarted) {
+				t.Fatalf("rollout() restarted %v, want %v", restarted, tt.wantRestarted)
+			}
+			for i := range restarted {
+				if restarted[i] != tt.wantRestarted[i] {
+					t.Fatalf("rollout() restarted %v, want %v", restarted, tt.wantRestarted)
+				}
+			}
+		})
+	}
+}
+
+func TestPendingWorkloads(t *testing.T) {
+	controller := true
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-5d9c",
+			Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "Deployment", Name: "web", Controller: &controller},
+			},
+		},
+	}
+	pod := func(kind, name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       "default",
+				OwnerReferences: []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}},
+			},
+		}
+	}
+	r := &SidecarGoReconciler{
+		Client: fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(rs).Build(),
+	}
+
+	workloads, err := r.pendingWorkloads(context.Background(), []*corev1.Pod{
+		pod("StatefulSet", "db"),
+		pod("ReplicaSet", "web-5d9c"),
+		pod("ReplicaSet", "web-5d9c"),
+		pod("ReplicaSet", "deleted"),
+		pod("Job", "migrate"),
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default"}},
+		pod("DaemonSet", "agent"),
+	})
+	if err != nil {
+		t.Fatalf("pendingWorkloads() error = %v", err)
+	}
+	want := []workload{
+		{kind: "DaemonSet", key: types.NamespacedName{Namespace: "default", Name: "agent"}},
+		{kind: "Deployment", key: types.NamespacedName{Namespace: "default", Name: "web"}},
+		{kind: "StatefulSet", key: types.NamespacedName{Namespace: "default", Name: "db"}},
+	}
+	if len(workloads) != len(want) {
+		t.Fatalf("pendingWorkloads() = %v, want %v", workloads, want)
+	}
+	for i := range want {
+		if workloads[i] != want[i] {
+			t.Errorf("pendingWorkloads()[%d] = %v, want %v", i, workloads[i], want[i])
+		}
+	}
+}
+
+// statefulSet returns a StatefulSet of the default namespace with the given pod template annotations.
+func statefulSet(name string, annotations map[string]string) *appsv1.StatefulSet {
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: appsv1.StatefulSetSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			},
+		},
+	}
+}
+
+func onDeleteStatefulSet(name string) *appsv1.StatefulSet {
+	sts := statefulSet(name, nil)
+	sts.Spec.UpdateStrategy.Type = appsv1.OnDeleteStatefulSetStrategyType
+	return sts
+}
+
+// restarted returns the annotations of a pod template restarted at the given time for the current revision,
+// which is filled in by the test.
+func restarted(at time.Time) map[string]string {
+	return map[string]string{util.AnnotationRestartedAt: at.Format(time.RFC3339)}
+}
+
+func statefulSetPod(name string) *corev1.Pod {
+	controller := true
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + "-0",
+			Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "StatefulSet", Name: name, Controller: &controller},
+			},
+		},
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/togettoyou/sidecar-go/pkg/cert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// WebhookConfigurationReconciler keeps the webhook configurations of the CertManager
+// in their desired state, recreating them when deleted and reverting any drift.
+type WebhookConfigurationReconciler struct {
+	client.Client
+	Scheme      *runtime.Scheme
+	CertManager *cert.Manager
+}
+
+// Reconcile applies both webhook configurations, whichever one changed.
+func (r *WebhookConfigurationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	return ctrl.Result{}, r.CertManager.ApplyWebhookConfigurations(ctx, r.Client)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *WebhookConfigurationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	owned := builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return r.CertManager.IsWebhookConfiguration(obj.GetName())
+	}))
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("webhookconfiguration").
+		For(&admissionregistrationv1.MutatingWebhookConfiguration{}, owned).
+		Watches(&source.Kind{Type: &admissionregistrationv1.ValidatingWebhookConfiguration{}}, &handler.EnqueueRequestForObject{}, owned).
+		Complete(r)
+}
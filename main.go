@@ -17,24 +17,34 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
 
 	v1 "github.com/togettoyou/sidecar-go/api/v1"
 	"github.com/togettoyou/sidecar-go/pkg/cert"
+	"github.com/togettoyou/sidecar-go/pkg/util"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	configv1alpha1 "github.com/togettoyou/sidecar-go/api/config/v1alpha1"
 	appsv1alpha1 "github.com/togettoyou/sidecar-go/api/v1alpha1"
 	"github.com/togettoyou/sidecar-go/controllers"
 	//+kubebuilder:scaffold:imports
@@ -43,13 +53,13 @@ import (
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
-	certDir  = "certs/"
 )
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(appsv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(configv1alpha1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -57,11 +67,46 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var configFile string
+	var webhookPort int
+	var certDir string
+	var excludedNamespaces string
+	injector := configv1alpha1.InjectorConfig{}
+	flag.StringVar(&configFile, "config", "",
+		"The controller will load its initial configuration from this file. "+
+			"Omit this flag to use the default configuration values. "+
+			"The values of this file override the defaults of the flags, flags set on the command line override this file.")
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.IntVar(&webhookPort, "webhook-port", 9443, "The port the webhook server serves at.")
+	flag.StringVar(&certDir, "cert-dir", "certs/", "The directory of the webhook server certificate.")
+	flag.StringVar(&injector.ServiceName, "webhook-service-name", "sidecar-go-service",
+		"The Service routing to the webhook server.")
+	flag.StringVar(&injector.ServiceNamespace, "webhook-service-namespace", "sidecar-go-system",
+		"The namespace of the webhook Service, which is never injected.")
+	flag.StringVar(&injector.WebhookURL, "webhook-url", "",
+		"The URL the API server calls the webhooks at instead of the Service, e.g. https://host.docker.internal:9443.")
+	flag.StringVar(&injector.InjectPath, "webhook-inject-path", "/mutate-core-v1-pod",
+		"The path of the pod mutating webhook.")
+	flag.StringVar(&injector.ValidatePath, "webhook-validate-path", "/validate-apps-togettoyou-com-v1alpha1-sidecargo",
+		"The path of the SidecarGo validating webhook.")
+	flag.StringVar(&excludedNamespaces, "excluded-namespaces", "kube-node-lease,kube-public,kube-system",
+		"Comma separated namespaces that are never injected.")
+	flag.StringVar(&injector.Class, "class", "",
+		"The injector class of this installation, which only injects the SidecarGo with the same spec.class.")
+	flag.StringVar(&injector.MutatingWebhookConfigurationName, "mutating-webhook-configuration-name", "",
+		"The name of the MutatingWebhookConfiguration of this installation, "+
+			"defaults to sidecar-go-<class>-mutating-webhook-configuration.")
+	flag.StringVar(&injector.ValidatingWebhookConfigurationName, "validating-webhook-configuration-name", "",
+		"The name of the ValidatingWebhookConfiguration of this installation, "+
+			"defaults to sidecar-go-<class>-validating-webhook-configuration.")
+	flag.StringVar(&injector.CertProvider, "cert-provider", cert.ProviderSelfSigned,
+		"The source of the webhook certificates: "+
+			"self-signed generates them, files reads tls.crt, tls.key and ca.crt from the cert dir, "+
+			"cert-manager serves the certificate issued by cert-manager and lets it inject the CA.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -70,10 +115,12 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	injector.ExcludedNamespaces = splitList(excludedNamespaces)
+	var err error
+	options := ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
+		Port:                   webhookPort,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "af21d624.togettoyou.com",
@@ -89,41 +136,117 @@ func main() {
 		// if you are doing or is intended to do any operation such as perform cleanups
 		// after the manager stops then its usage might be unsafe.
 		// LeaderElectionReleaseOnCancel: true,
-	})
+	}
+	if configFile != "" {
+		setFlags := sets.NewString()
+		flag.Visit(func(f *flag.Flag) { setFlags.Insert(f.Name) })
+		options, injector, err = loadConfigFile(configFile, options, injector, setFlags)
+		if err != nil {
+			setupLog.Error(err, "unable to load the config file")
+			os.Exit(1)
+		}
+	}
+	if injector.Class != "" {
+		// installations of other classes may share the namespace
+		options.LeaderElectionID = injector.Class + "." + options.LeaderElectionID
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
-	err = cert.Init(&cert.Manager{
-		Client:            mgr.GetClient(),
-		CertDir:           certDir,
-		WebhookInjectPath: "/mutate-core-v1-pod",
-		ServiceName:       "sidecar-go-service",
-		Namespace:         "sidecar-go-system",
-		//WebhookURL:        "https://host.docker.internal:9443/mutate-core-v1-pod",
-	})
+	provider, err := cert.NewProvider(injector.CertProvider)
+	if err != nil {
+		setupLog.Error(err, "unable to init cert")
+		os.Exit(1)
+	}
+	certManager := &cert.Manager{
+		Provider:                           provider,
+		Client:                             mgr.GetClient(),
+		APIReader:                          mgr.GetAPIReader(),
+		CertDir:                            options.CertDir,
+		WebhookURL:                         injector.WebhookURL,
+		WebhookInjectPath:                  injector.InjectPath,
+		WebhookValidatePath:                injector.ValidatePath,
+		ServiceName:                        injector.ServiceName,
+		Namespace:                          injector.ServiceNamespace,
+		ExcludedNamespaces:                 injector.ExcludedNamespaces,
+		MutatingWebhookConfigurationName:   injector.MutatingWebhookConfigurationName,
+		ValidatingWebhookConfigurationName: injector.ValidatingWebhookConfigurationName,
+		Class:                              injector.Class,
+	}
+	err = cert.Init(certManager)
 	if err != nil {
 		setupLog.Error(err, "unable to init cert")
 		os.Exit(1)
 	}
+	if err = mgr.Add(certManager); err != nil {
+		setupLog.Error(err, "unable to set up cert rotation")
+		os.Exit(1)
+	}
 
-	if err = (&controllers.SidecarGoReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
+	// the pod webhook looks up namespace labels, and the ReplicaSets and Jobs owning pods, from the cache
+	for _, obj := range []client.Object{&corev1.Namespace{}, &appsv1.ReplicaSet{}, &batchv1.Job{}} {
+		if _, err = mgr.GetCache().GetInformer(context.Background(), obj); err != nil {
+			setupLog.Error(err, "unable to set up informer", "kind", fmt.Sprintf("%T", obj))
+			os.Exit(1)
+		}
+	}
+
+	// every replica loads the SidecarGo, the webhook is not ready until they are loaded
+	store := util.NewSpecStore(mgr.GetCache(), mgr.GetAPIReader(), injector.Class)
+	// the same namespaces as the namespace selector of the pod webhook
+	store.ExcludedNamespaces = sets.NewString(injector.ExcludedNamespaces...).Insert(injector.ServiceNamespace)
+	if err = mgr.Add(store); err != nil {
+		setupLog.Error(err, "unable to set up SidecarGo store")
+		os.Exit(1)
+	}
+
+	sidecarGoReconciler := controllers.SidecarGoReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Class:    injector.Class,
+		Recorder: mgr.GetEventRecorderFor("sidecargo-controller"),
+		Store:    store,
+	}
+	if err = (&sidecarGoReconciler).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "SidecarGo")
 		os.Exit(1)
 	}
-	mgr.GetWebhookServer().Register("/mutate-core-v1-pod",
-		&webhook.Admission{Handler: v1.NewPodMutate(mgr.GetClient())})
+	if err = (&controllers.ClusterSidecarGoReconciler{
+		SidecarGoReconciler: sidecarGoReconciler,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterSidecarGo")
+		os.Exit(1)
+	}
+	if err = (&controllers.WebhookConfigurationReconciler{
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		CertManager: certManager,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "WebhookConfiguration")
+		os.Exit(1)
+	}
+	nativeSidecars, err := util.NativeSidecarSupported(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to detect native sidecar support")
+		os.Exit(1)
+	}
+	setupLog.Info("detected native sidecar support", "enabled", nativeSidecars)
+	mgr.GetWebhookServer().Register(injector.InjectPath,
+		&webhook.Admission{Handler: v1.NewPodMutate(mgr.GetClient(), mgr.GetAPIReader(), store, nativeSidecars, mgr.GetEventRecorderFor("sidecar-go-webhook"))})
+	mgr.GetWebhookServer().Register(v1.DryRunPath, v1.NewPodDryRun(mgr.GetClient(), store, nativeSidecars))
+	mgr.GetWebhookServer().Register(injector.ValidatePath,
+		&webhook.Admission{Handler: appsv1alpha1.NewSidecarGoValidate(mgr.GetClient())})
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+	if err := mgr.AddReadyzCheck("readyz", store.ReadyCheck); err != nil {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
@@ -134,3 +257,76 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// loadConfigFile loads the manager options and the injector configuration of the config file.
+// options and injector hold the values of the flags: the values of the file override their defaults,
+// and the flags named in setFlags, set on the command line, override the file.
+func loadConfigFile(path string, options ctrl.Options, injector configv1alpha1.InjectorConfig, setFlags sets.String) (ctrl.Options, configv1alpha1.InjectorConfig, error) {
+	// the flags are the defaults of the values missing from the file
+	ctrlConfig := configv1alpha1.SidecarGoConfig{Injector: *injector.DeepCopy()}
+	loader := ctrl.ConfigFile().AtPath(path).OfKind(&ctrlConfig)
+	if err := loader.InjectScheme(scheme); err != nil {
+		return options, injector, err
+	}
+	// AndFrom only fills the options left empty, so it is applied to empty options
+	// before the flags fill the values still missing
+	fileOptions, err := ctrl.Options{Scheme: options.Scheme}.AndFrom(loader)
+	if err != nil {
+		return options, injector, err
+	}
+	fileInjector := ctrlConfig.Injector
+
+	useFlag := func(name string, missing bool) bool {
+		return missing || setFlags.Has(name)
+	}
+	if useFlag("metrics-bind-address", fileOptions.MetricsBindAddress == "") {
+		fileOptions.MetricsBindAddress = options.MetricsBindAddress
+	}
+	if useFlag("health-probe-bind-address", fileOptions.HealthProbeBindAddress == "") {
+		fileOptions.HealthProbeBindAddress = options.HealthProbeBindAddress
+	}
+	if useFlag("leader-elect", ctrlConfig.LeaderElection == nil || ctrlConfig.LeaderElection.LeaderElect == nil) {
+		fileOptions.LeaderElection = options.LeaderElection
+	}
+	if fileOptions.LeaderElectionID == "" {
+		fileOptions.LeaderElectionID = options.LeaderElectionID
+	}
+	if useFlag("webhook-port", fileOptions.Port == 0) {
+		fileOptions.Port = options.Port
+	}
+	if useFlag("cert-dir", fileOptions.CertDir == "") {
+		fileOptions.CertDir = options.CertDir
+	}
+
+	// the injector values missing from the file already are the values of the flags
+	for name, value := range map[string]struct{ file, flag *string }{
+		"webhook-service-name":                  {&fileInjector.ServiceName, &injector.ServiceName},
+		"webhook-service-namespace":             {&fileInjector.ServiceNamespace, &injector.ServiceNamespace},
+		"webhook-url":                           {&fileInjector.WebhookURL, &injector.WebhookURL},
+		"webhook-inject-path":                   {&fileInjector.InjectPath, &injector.InjectPath},
+		"webhook-validate-path":                 {&fileInjector.ValidatePath, &injector.ValidatePath},
+		"class":                                 {&fileInjector.Class, &injector.Class},
+		"mutating-webhook-configuration-name":   {&fileInjector.MutatingWebhookConfigurationName, &injector.MutatingWebhookConfigurationName},
+		"validating-webhook-configuration-name": {&fileInjector.ValidatingWebhookConfigurationName, &injector.ValidatingWebhookConfigurationName},
+		"cert-provider":                         {&fileInjector.CertProvider, &injector.CertProvider},
+	} {
+		if setFlags.Has(name) {
+			*value.file = *value.flag
+		}
+	}
+	if setFlags.Has("excluded-namespaces") {
+		fileInjector.ExcludedNamespaces = injector.ExcludedNamespaces
+	}
+	return fileOptions, fileInjector, nil
+}
+
+// splitList splits a comma separated flag value, dropping empty items.
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
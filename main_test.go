@@ -0,0 +1,144 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/sets"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	configv1alpha1 "github.com/togettoyou/sidecar-go/api/config/v1alpha1"
+)
+
+// managerOptions are the ctrl.Options set by flags, which holds functions DeepEqual can not compare.
+type managerOptions struct {
+	MetricsBindAddress     string
+	HealthProbeBindAddress string
+	Port                   int
+	LeaderElection         bool
+	LeaderElectionID       string
+	CertDir                string
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configFile, []byte(`apiVersion: config.togettoyou.com/v1alpha1
+kind: SidecarGoConfig
+metrics:
+  bindAddress: 127.0.0.1:8080
+webhook:
+  port: 9443
+leaderElection:
+  leaderElect: true
+injector:
+  serviceNamespace: file-system
+  excludedNamespaces:
+  - kube-system
+  class: file
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the values of the flags, as defaults or as set on the command line
+	flagOptions := ctrl.Options{
+		Scheme:                 scheme,
+		MetricsBindAddress:     ":8080",
+		HealthProbeBindAddress: ":8081",
+		Port:                   9000,
+		LeaderElectionID:       "af21d624.togettoyou.com",
+		CertDir:                "certs/",
+	}
+	flagInjector := configv1alpha1.InjectorConfig{
+		ServiceName:        "sidecar-go-service",
+		ServiceNamespace:   "sidecar-go-system",
+		ExcludedNamespaces: []string{"kube-public"},
+		Class:              "flag",
+		CertProvider:       "self-signed",
+	}
+
+	tests := []struct {
+		name         string
+		setFlags     sets.String
+		wantOptions  managerOptions
+		wantInjector configv1alpha1.InjectorConfig
+	}{
+		{
+			name:     "file overrides the defaults",
+			setFlags: sets.NewString(),
+			wantOptions: managerOptions{
+				MetricsBindAddress:     "127.0.0.1:8080",
+				HealthProbeBindAddress: ":8081",
+				Port:                   9443,
+				LeaderElection:         true,
+				LeaderElectionID:       "af21d624.togettoyou.com",
+				CertDir:                "certs/",
+			},
+			wantInjector: configv1alpha1.InjectorConfig{
+				ServiceName:        "sidecar-go-service",
+				ServiceNamespace:   "file-system",
+				ExcludedNamespaces: []string{"kube-system"},
+				Class:              "file",
+				CertProvider:       "self-signed",
+			},
+		},
+		{
+			name:     "set flags override the file",
+			setFlags: sets.NewString("metrics-bind-address", "webhook-port", "leader-elect", "class", "excluded-namespaces", "config"),
+			wantOptions: managerOptions{
+				MetricsBindAddress:     ":8080",
+				HealthProbeBindAddress: ":8081",
+				Port:                   9000,
+				LeaderElection:         false,
+				LeaderElectionID:       "af21d624.togettoyou.com",
+				CertDir:                "certs/",
+			},
+			wantInjector: configv1alpha1.InjectorConfig{
+				ServiceName:        "sidecar-go-service",
+				ServiceNamespace:   "file-system",
+				ExcludedNamespaces: []string{"kube-public"},
+				Class:              "flag",
+				CertProvider:       "self-signed",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, injector, err := loadConfigFile(configFile, flagOptions, flagInjector, tt.setFlags)
+			if err != nil {
+				t.Fatalf("loadConfigFile() error = %v", err)
+			}
+			got := managerOptions{
+				MetricsBindAddress:     options.MetricsBindAddress,
+				HealthProbeBindAddress: options.HealthProbeBindAddress,
+				Port:                   options.Port,
+				LeaderElection:         options.LeaderElection,
+				LeaderElectionID:       options.LeaderElectionID,
+				CertDir:                options.CertDir,
+			}
+			if !equality.Semantic.DeepEqual(got, tt.wantOptions) {
+				t.Errorf("loadConfigFile() options = %+v, want %+v", got, tt.wantOptions)
+			}
+			if !equality.Semantic.DeepEqual(injector, tt.wantInjector) {
+				t.Errorf("loadConfigFile() injector = %+v, want %+v", injector, tt.wantInjector)
+			}
+		})
+	}
+}
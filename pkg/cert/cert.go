@@ -7,7 +7,6 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
-	"encoding/pem"
 	"fmt"
 	"math/big"
 	"net/url"
@@ -15,33 +14,91 @@ import (
 	"path"
 	"time"
 
-	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const (
-	_projectName           = "sidecar-go"
-	_webhookObjectMetaName = "sidecar-go-mutating-webhook-configuration"
-	_webhookName           = "sidecar-go.togettoyou.com"
+	_projectName                     = "sidecar-go"
+	_webhookObjectMetaName           = "mutating-webhook-configuration"
+	_webhookName                     = "sidecar-go.togettoyou.com"
+	_validatingWebhookObjectMetaName = "validating-webhook-configuration"
+	_validatingWebhookName           = "validate.sidecar-go.togettoyou.com"
 )
 
 type Manager struct {
-	Client            client.Client
-	CertDir           string
-	WebhookURL        string
-	WebhookInjectPath string
-	ServiceName       string
-	Namespace         string
-	orgs              []string
-	commonName        string
-	dnsNames          []string
+	Client              client.Client
+	CertDir             string
+	WebhookURL          string
+	WebhookInjectPath   string
+	WebhookValidatePath string
+	ServiceName         string
+	Namespace           string
+	// ExcludedNamespaces are never sent to the pod webhook, in addition to Namespace.
+	ExcludedNamespaces []string
+	// Class is the injector class of the installation. The default names of the Secret and the
+	// webhook configurations are prefixed with sidecar-go-<Class>, or sidecar-go without class.
+	Class string
+	// Provider supplies the certificates, defaults to a SelfSignedProvider.
+	Provider Provider
+	// APIReader reads the certificate Secret from the API server, as the cache is not
+	// started yet when Init runs. Defaults to Client.
+	APIReader client.Reader
+	// SecretName is the Secret in Namespace that shares the self-signed certificates between replicas.
+	SecretName string
+	// MutatingWebhookConfigurationName and ValidatingWebhookConfigurationName name the
+	// webhook configurations kept up to date by the Manager.
+	MutatingWebhookConfigurationName   string
+	ValidatingWebhookConfigurationName string
+	// Validity is how long self-signed certificates are valid, defaults to one year.
+	Validity time.Duration
+	// RotateBefore is how long before expiry self-signed certificates are renewed, defaults to 30 days.
+	RotateBefore time.Duration
+	// CheckInterval is how often the certificates are checked for renewal or change, defaults to one hour.
+	CheckInterval time.Duration
+	orgs          []string
+	commonName    string
+	dnsNames      []string
+	// certs are the certificates last loaded by the rotation of this replica.
+	certs *Certificates
+}
+
+type keyPair struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
 }
 
 func Init(m *Manager) error {
 	m.orgs = []string{_projectName}
 	m.commonName = _projectName
 	m.dnsNames = []string{fmt.Sprintf("%s.%s.svc", m.ServiceName, m.Namespace)}
+	if m.Validity == 0 {
+		m.Validity = 365 * 24 * time.Hour
+	}
+	if m.RotateBefore == 0 {
+		m.RotateBefore = 30 * 24 * time.Hour
+	}
+	if m.CheckInterval == 0 {
+		m.CheckInterval = time.Hour
+	}
+	namePrefix := _projectName
+	if m.Class != "" {
+		namePrefix += "-" + m.Class
+	}
+	if m.SecretName == "" {
+		m.SecretName = namePrefix + "-" + _secretName
+	}
+	if m.MutatingWebhookConfigurationName == "" {
+		m.MutatingWebhookConfigurationName = namePrefix + "-" + _webhookObjectMetaName
+	}
+	if m.ValidatingWebhookConfigurationName == "" {
+		m.ValidatingWebhookConfigurationName = namePrefix + "-" + _validatingWebhookObjectMetaName
+	}
+	if m.APIReader == nil {
+		m.APIReader = m.Client
+	}
+	if m.Provider == nil {
+		m.Provider = &SelfSignedProvider{}
+	}
 
 	if m.WebhookURL != "" {
 		u, err := url.Parse(m.WebhookURL)
@@ -51,20 +108,32 @@ func Init(m *Manager) error {
 		m.dnsNames = append(m.dnsNames, u.Hostname())
 	}
 
-	caPEM, err := m.createCert()
+	certs, err := m.Provider.Load(context.Background(), m)
 	if err != nil {
 		return err
 	}
+	if certs.server != nil {
+		err = m.writeServerCert(certs.server)
+		if err != nil {
+			return err
+		}
+	}
+	m.certs = certs
 
-	return m.createMutatingWebhookConfiguration(caPEM)
+	return m.applyWebhookConfigurations(context.Background(), m.APIReader, certs)
 }
 
-func (m *Manager) createCert() (*bytes.Buffer, error) {
+// createCert creates a new CA, and a server certificate signed by it.
+func (m *Manager) createCert() (*keyPair, *keyPair, error) {
+	caSerialNumber, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
 	ca := &x509.Certificate{
-		SerialNumber:          big.NewInt(2048),
+		SerialNumber:          caSerialNumber,
 		Subject:               pkix.Name{Organization: m.orgs},
 		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(1, 0, 0),
+		NotAfter:              time.Now().Add(m.Validity),
 		IsCA:                  true,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
 		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
@@ -73,155 +142,94 @@ func (m *Manager) createCert() (*bytes.Buffer, error) {
 
 	caPrivateKey, err := rsa.GenerateKey(rand.Reader, 4096)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	caBytes, err := x509.CreateCertificate(rand.Reader, ca, ca, &caPrivateKey.PublicKey, caPrivateKey)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-
-	caPEM := new(bytes.Buffer)
-	err = pem.Encode(caPEM, &pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: caBytes,
-	})
+	ca, err = x509.ParseCertificate(caBytes)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
+	serverSerialNumber, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
 	cert := &x509.Certificate{
 		DNSNames:     m.dnsNames,
-		SerialNumber: big.NewInt(1024),
+		SerialNumber: serverSerialNumber,
 		Subject: pkix.Name{
 			CommonName:   m.commonName,
 			Organization: m.orgs,
 		},
 		NotBefore:   time.Now(),
-		NotAfter:    time.Now().AddDate(1, 0, 0),
+		NotAfter:    time.Now().Add(m.Validity),
 		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
 		KeyUsage:    x509.KeyUsageDigitalSignature,
 	}
 
 	serverPrivateKey, err := rsa.GenerateKey(rand.Reader, 4096)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	serverCertBytes, err := x509.CreateCertificate(rand.Reader, cert, ca, &serverPrivateKey.PublicKey, caPrivateKey)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-
-	serverCertPEM := new(bytes.Buffer)
-	err = pem.Encode(serverCertPEM, &pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: serverCertBytes,
-	})
+	cert, err = x509.ParseCertificate(serverCertBytes)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	serverPrivateKeyPEM := new(bytes.Buffer)
-	err = pem.Encode(serverPrivateKeyPEM, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(serverPrivateKey),
-	})
+	return &keyPair{cert: ca, key: caPrivateKey}, &keyPair{cert: cert, key: serverPrivateKey}, nil
+}
+
+// writeServerCert writes the server certificate to CertDir, where the webhook server watches it.
+func (m *Manager) writeServerCert(server *keyPair) error {
+	serverCertPEM, err := encodeCert(server.cert)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	fmt.Println(m.CertDir)
-	err = os.MkdirAll(m.CertDir, os.ModePerm)
+	serverPrivateKeyPEM, err := encodeKey(server.key)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	err = writeFile(path.Join(m.CertDir, "tls.crt"), serverCertPEM)
+
+	err = os.MkdirAll(m.CertDir, os.ModePerm)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	err = writeFile(path.Join(m.CertDir, "tls.key"), serverPrivateKeyPEM)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	return caPEM, nil
+	return writeFile(path.Join(m.CertDir, "tls.crt"), serverCertPEM)
 }
 
-func (m *Manager) createMutatingWebhookConfiguration(caPEM *bytes.Buffer) error {
-	clientConfig := admissionregistrationv1.WebhookClientConfig{
-		CABundle: caPEM.Bytes(),
-	}
-	if m.WebhookURL != "" {
-		clientConfig.URL = &m.WebhookURL
-	} else {
-		clientConfig.Service = &admissionregistrationv1.ServiceReference{
-			Name:      m.ServiceName,
-			Namespace: m.Namespace,
-			Path:      &m.WebhookInjectPath,
-		}
-	}
-
-	mutatingWebhookConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: _webhookObjectMetaName,
-		},
-		Webhooks: []admissionregistrationv1.MutatingWebhook{{
-			Name:                    _webhookName,
-			AdmissionReviewVersions: []string{"v1"},
-			SideEffects: func() *admissionregistrationv1.SideEffectClass {
-				se := admissionregistrationv1.SideEffectClassNone
-				return &se
-			}(),
-			ClientConfig: clientConfig,
-			Rules: []admissionregistrationv1.RuleWithOperations{
-				{
-					Operations: []admissionregistrationv1.OperationType{
-						admissionregistrationv1.Create,
-						admissionregistrationv1.Update,
-					},
-					Rule: admissionregistrationv1.Rule{
-						APIGroups:   []string{""},
-						APIVersions: []string{"v1"},
-						Resources:   []string{"pods"},
-					},
-				},
-			},
-			FailurePolicy: func() *admissionregistrationv1.FailurePolicyType {
-				pt := admissionregistrationv1.Ignore
-				return &pt
-			}(),
-			NamespaceSelector: &metav1.LabelSelector{
-				MatchExpressions: []metav1.LabelSelectorRequirement{
-					{
-						Key:      "kubernetes.io/metadata.name",
-						Operator: metav1.LabelSelectorOpNotIn,
-						Values: []string{
-							"kube-node-lease",
-							"kube-public",
-							"kube-system",
-							"sidecar-go-system",
-						},
-					},
-				},
-			},
-		}},
-	}
-
-	_ = m.Client.Delete(context.Background(), mutatingWebhookConfig)
-	return m.Client.Create(context.Background(), mutatingWebhookConfig)
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
 }
 
+// writeFile replaces the file atomically, so the webhook server never reads a partial file.
 func writeFile(filepath string, content *bytes.Buffer) error {
-	f, err := os.Create(filepath)
+	f, err := os.CreateTemp(path.Dir(filepath), path.Base(filepath)+".tmp")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer os.Remove(f.Name())
 
 	_, err = f.Write(content.Bytes())
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
 	if err != nil {
 		return err
 	}
-	return nil
+	return os.Rename(f.Name(), filepath)
 }
@@ -0,0 +1,172 @@
+package cert
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/fsnotify/fsnotify"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	ProviderSelfSigned  = "self-signed"
+	ProviderFiles       = "files"
+	ProviderCertManager = "cert-manager"
+
+	_certManagerSecretName = "webhook-server-cert"
+	_certManagerCertName   = "sidecar-go-serving-cert"
+	// _injectCAFromAnnotation makes the cert-manager CA injector set the CA bundle of the webhook configurations.
+	_injectCAFromAnnotation = "cert-manager.io/inject-ca-from"
+)
+
+// Provider supplies the certificates of the webhook server.
+type Provider interface {
+	// Load returns the current certificates, renewing them if the provider issues them.
+	// It is called by Init, and then every CheckInterval of the Manager.
+	Load(ctx context.Context, m *Manager) (*Certificates, error)
+}
+
+// Watcher is implemented by providers that can tell when their certificates changed
+// between two checks.
+type Watcher interface {
+	// Watch sends on the returned channel when the certificates may have changed, until ctx is done.
+	Watch(ctx context.Context, m *Manager) (<-chan struct{}, error)
+}
+
+// Certificates are the certificates loaded by a Provider.
+type Certificates struct {
+	// CABundle is trusted by the API server to call the webhooks, the current CA first.
+	CABundle []*x509.Certificate
+	// Annotations are set on the webhook configurations.
+	Annotations map[string]string
+	// CAInjected means the CA bundle of the webhook configurations is kept up to date by
+	// someone else, so CABundle is only set on webhook configurations that have none.
+	CAInjected bool
+	// server is written to CertDir. Providers leaving it nil keep CertDir up to date themselves.
+	server *keyPair
+}
+
+// NewProvider returns the Provider with the given name.
+func NewProvider(name string) (Provider, error) {
+	switch name {
+	case "", ProviderSelfSigned:
+		return &SelfSignedProvider{}, nil
+	case ProviderFiles:
+		return &FilesProvider{}, nil
+	case ProviderCertManager:
+		return &CertManagerProvider{}, nil
+	}
+	return nil, fmt.Errorf("unknown certificate provider %q, must be one of %s, %s or %s",
+		name, ProviderSelfSigned, ProviderFiles, ProviderCertManager)
+}
+
+// SelfSignedProvider creates a self-signed CA and server certificate, shares them between
+// replicas through the Secret of the Manager, and renews them ahead of expiry.
+type SelfSignedProvider struct{}
+
+func (p *SelfSignedProvider) Load(ctx context.Context, m *Manager) (*Certificates, error) {
+	certs, err := m.sync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Certificates{CABundle: certs.caBundle, server: certs.server}, nil
+}
+
+// FilesProvider serves the tls.crt and tls.key mounted in CertDir, and trusts the CA bundle in CAFile.
+// The webhook server reloads the server certificate on change, and so does the provider for the CA bundle.
+type FilesProvider struct {
+	// CAFile defaults to ca.crt in CertDir.
+	CAFile string
+}
+
+func (p *FilesProvider) Load(ctx context.Context, m *Manager) (*Certificates, error) {
+	data, err := os.ReadFile(p.caFile(m))
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := parseCerts(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.caFile(m), err)
+	}
+	return &Certificates{CABundle: bundle}, nil
+}
+
+// Watch watches the directory of CAFile, as mounted Secrets and ConfigMaps are
+// updated by swapping a symlink rather than by writing the file.
+func (p *FilesProvider) Watch(ctx context.Context, m *Manager) (<-chan struct{}, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(path.Dir(p.caFile(m))); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	changed := make(chan struct{}, 1)
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-watcher.Events:
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			case err := <-watcher.Errors:
+				log.Error(err, "unable to watch certificate files")
+			}
+		}
+	}()
+	return changed, nil
+}
+
+func (p *FilesProvider) caFile(m *Manager) string {
+	if p.CAFile != "" {
+		return p.CAFile
+	}
+	return path.Join(m.CertDir, "ca.crt")
+}
+
+// CertManagerProvider serves the certificate issued by cert-manager, whose Secret is mounted in CertDir.
+// The CA bundle is injected into the webhook configurations by the cert-manager CA injector,
+// the provider only reads the CA from the Secret until the injector did so.
+type CertManagerProvider struct {
+	// SecretName is the Secret in the namespace of the Manager holding the issued certificate,
+	// defaults to webhook-server-cert.
+	SecretName string
+	// Certificate is the name of the cert-manager Certificate in the namespace of the Manager,
+	// defaults to sidecar-go-serving-cert.
+	Certificate string
+}
+
+func (p *CertManagerProvider) Load(ctx context.Context, m *Manager) (*Certificates, error) {
+	secretName, certName := p.SecretName, p.Certificate
+	if secretName == "" {
+		secretName = _certManagerSecretName
+	}
+	if certName == "" {
+		certName = _certManagerCertName
+	}
+
+	secret := &corev1.Secret{}
+	err := m.APIReader.Get(ctx, types.NamespacedName{Namespace: m.Namespace, Name: secretName}, secret)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := parseCerts(secret.Data[_caCertKey])
+	if err != nil {
+		return nil, fmt.Errorf("secret %s/%s %s: %w", m.Namespace, secretName, _caCertKey, err)
+	}
+	return &Certificates{
+		CABundle:    bundle,
+		Annotations: map[string]string{_injectCAFromAnnotation: m.Namespace + "/" + certName},
+		CAInjected:  true,
+	}, nil
+}
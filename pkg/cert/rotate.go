@@ -0,0 +1,84 @@
+package cert
+
+import (
+	"context"
+	"crypto/x509"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var log = logf.Log.WithName("cert")
+
+// Start checks the certificates every CheckInterval, and whenever a Watcher provider
+// reports a change, until ctx is done. It implements manager.Runnable.
+func (m *Manager) Start(ctx context.Context) error {
+	ticker := time.NewTicker(m.CheckInterval)
+	defer ticker.Stop()
+
+	var changed <-chan struct{}
+	if watcher, ok := m.Provider.(Watcher); ok {
+		var err error
+		changed, err = watcher.Watch(ctx, m)
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-changed:
+		}
+		if err := m.rotateIfNeeded(ctx); err != nil {
+			log.Error(err, "unable to rotate certificate")
+		}
+	}
+}
+
+// NeedLeaderElection returns false, as every replica writes the shared certificate for its own webhook server.
+func (m *Manager) NeedLeaderElection() bool {
+	return false
+}
+
+// rotateIfNeeded loads the certificates from the Provider, which renews them if it issues them,
+// and applies the ones that changed. A new CA is trusted before the new server certificate is served,
+// and providers keep the previous CAs in the bundle, so that requests keep working during the overlap.
+func (m *Manager) rotateIfNeeded(ctx context.Context) error {
+	certs, err := m.Provider.Load(ctx, m)
+	if err != nil {
+		return err
+	}
+
+	previous := m.certs
+	m.certs = certs
+
+	if !equalCerts(previous.CABundle, certs.CABundle) || !equality.Semantic.DeepEqual(previous.Annotations, certs.Annotations) {
+		err = m.applyWebhookConfigurations(ctx, m.APIReader, certs)
+		if err != nil {
+			return err
+		}
+	}
+
+	if certs.server != nil && (previous.server == nil || !previous.server.cert.Equal(certs.server.cert)) {
+		err = m.writeServerCert(certs.server)
+		if err != nil {
+			return err
+		}
+		log.Info("serving rotated certificate", "notAfter", certs.server.cert.NotAfter)
+	}
+	return nil
+}
+
+func unexpired(certs []*x509.Certificate, now time.Time) []*x509.Certificate {
+	valid := make([]*x509.Certificate, 0, len(certs))
+	for _, cert := range certs {
+		if now.Before(cert.NotAfter) {
+			valid = append(valid, cert)
+		}
+	}
+	return valid
+}
@@ -0,0 +1,195 @@
+package cert
+
+import (
+	"crypto/x509"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	testCertsOnce sync.Once
+	testCerts     [2]*certificates
+	testCertsErr  error
+)
+
+func newTestManager(dnsNames ...string) *Manager {
+	if len(dnsNames) == 0 {
+		dnsNames = []string{"sidecar-go-webhook-service.sidecar-go-system.svc"}
+	}
+	return &Manager{
+		Validity:     365 * 24 * time.Hour,
+		RotateBefore: 30 * 24 * time.Hour,
+		orgs:         []string{_projectName},
+		commonName:   _projectName,
+		dnsNames:     dnsNames,
+	}
+}
+
+// newTestCertificates returns two sets of certificates of newTestManager, created once
+// as generating the keys is slow. Tests must not modify them.
+func newTestCertificates(t *testing.T) (*certificates, *certificates) {
+	t.Helper()
+	testCertsOnce.Do(func() {
+		m := newTestManager()
+		for i := range testCerts {
+			ca, server, err := m.createCert()
+			if err != nil {
+				testCertsErr = err
+				return
+			}
+			testCerts[i] = &certificates{ca: ca, server: server, caBundle: []*x509.Certificate{ca.cert}}
+		}
+	})
+	if testCertsErr != nil {
+		t.Fatalf("createCert() error = %v", testCertsErr)
+	}
+	return testCerts[0], testCerts[1]
+}
+
+func TestValid(t *testing.T) {
+	m := newTestManager()
+	certs, other := newTestCertificates(t)
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		m     *Manager
+		certs *certificates
+		now   time.Time
+		want  bool
+	}{
+		{
+			name:  "valid",
+			m:     m,
+			certs: certs,
+			now:   now,
+			want:  true,
+		},
+		{
+			name:  "expires after RotateBefore",
+			m:     m,
+			certs: certs,
+			now:   certs.server.cert.NotAfter.Add(-m.RotateBefore - time.Hour),
+			want:  true,
+		},
+		{
+			name:  "expires within RotateBefore",
+			m:     m,
+			certs: certs,
+			now:   certs.server.cert.NotAfter.Add(-m.RotateBefore + time.Hour),
+			want:  false,
+		},
+		{
+			name:  "signed by another CA",
+			m:     m,
+			certs: &certificates{ca: other.ca, server: certs.server, caBundle: other.caBundle},
+			now:   now,
+			want:  false,
+		},
+		{
+			name:  "DNS name not covered",
+			m:     newTestManager("sidecar-go-webhook-service.other.svc"),
+			certs: certs,
+			now:   now,
+			want:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.m.valid(tt.certs, tt.now); got != tt.want {
+				t.Errorf("valid() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenew(t *testing.T) {
+	m := newTestManager()
+	certs, previous := newTestCertificates(t)
+	now := time.Now()
+	rotateAt := certs.server.cert.NotAfter.Add(-m.RotateBefore + time.Hour)
+
+	tests := []struct {
+		name  string
+		certs *certificates
+		now   time.Time
+		// renewed is whether new certificates are created, kept whether certs are returned unchanged.
+		renewed bool
+		kept    bool
+		// bundle are the CAs expected in the bundle after the current one.
+		bundle []*x509.Certificate
+	}{
+		{
+			name:    "missing",
+			certs:   nil,
+			now:     now,
+			renewed: true,
+		},
+		{
+			name:  "valid",
+			certs: certs,
+			now:   now,
+			kept:  true,
+		},
+		{
+			name:    "about to expire keeps the previous CA",
+			certs:   certs,
+			now:     rotateAt,
+			renewed: true,
+			bundle:  []*x509.Certificate{certs.ca.cert},
+		},
+		{
+			name: "expired previous CA is dropped",
+			certs: &certificates{
+				ca:       certs.ca,
+				server:   certs.server,
+				caBundle: []*x509.Certificate{certs.ca.cert, expiredCert(previous.ca.cert)},
+			},
+			now: now,
+		},
+		{
+			name: "unexpired previous CA is kept",
+			certs: &certificates{
+				ca:       certs.ca,
+				server:   certs.server,
+				caBundle: []*x509.Certificate{certs.ca.cert, previous.ca.cert},
+			},
+			now:  now,
+			kept: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.renew(tt.certs, tt.now)
+			if err != nil {
+				t.Fatalf("renew() error = %v", err)
+			}
+			if tt.kept {
+				if got != nil {
+					t.Errorf("renew() = %+v, want the certificates kept", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("renew() = nil, want new certificates")
+			}
+			if renewed := tt.certs == nil || !got.server.cert.Equal(tt.certs.server.cert); renewed != tt.renewed {
+				t.Errorf("renew() renewed the server certificate = %v, want %v", renewed, tt.renewed)
+			}
+			if !got.caBundle[0].Equal(got.ca.cert) {
+				t.Error("renew() bundle does not start with the current CA")
+			}
+			if !equalCerts(got.caBundle[1:], tt.bundle) {
+				t.Errorf("renew() bundle has %d previous CAs, want %d", len(got.caBundle)-1, len(tt.bundle))
+			}
+		})
+	}
+}
+
+// expiredCert returns a copy of cert that expired a day ago.
+func expiredCert(cert *x509.Certificate) *x509.Certificate {
+	expired := *cert
+	expired.NotAfter = time.Now().Add(-24 * time.Hour)
+	return &expired
+}
@@ -0,0 +1,272 @@
+package cert
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+//+kubebuilder:rbac:groups="",namespace=sidecar-go-system,resources=secrets,verbs=get;create;update
+
+const (
+	_secretName = "webhook-certs"
+
+	_caCertKey     = "ca.crt"
+	_caKeyKey      = "ca.key"
+	_serverCertKey = corev1.TLSCertKey
+	_serverKeyKey  = corev1.TLSPrivateKeyKey
+
+	// _syncRetries bounds how often sync starts over after another replica changed the Secret.
+	_syncRetries = 5
+)
+
+// certificates are the certificates shared by all replicas through the Secret.
+type certificates struct {
+	ca     *keyPair
+	server *keyPair
+	// caBundle holds the current CA first, followed by previous CAs until they expire.
+	caBundle []*x509.Certificate
+}
+
+// sync loads the certificates from the Secret, creating or renewing them when they are
+// missing, invalid or about to expire. Replicas race on the resourceVersion of the Secret,
+// and the losers start over with the certificates of the winner.
+func (m *Manager) sync(ctx context.Context) (*certificates, error) {
+	key := types.NamespacedName{Namespace: m.Namespace, Name: m.SecretName}
+	for i := 0; i < _syncRetries; i++ {
+		secret := &corev1.Secret{}
+		err := m.APIReader.Get(ctx, key, secret)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		found := err == nil
+
+		var certs *certificates
+		if found {
+			certs, err = parseCertificates(secret.Data)
+			if err != nil {
+				log.Info("ignoring invalid certificate secret", "secret", key, "reason", err.Error())
+			}
+		}
+
+		renewed, err := m.renew(certs, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if renewed == nil {
+			return certs, nil
+		}
+		data, err := renewed.encode()
+		if err != nil {
+			return nil, err
+		}
+
+		if found {
+			secret.Data = data
+			err = m.Client.Update(ctx, secret)
+			if apierrors.IsConflict(err) {
+				continue
+			}
+		} else {
+			err = m.Client.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      m.SecretName,
+					Namespace: m.Namespace,
+				},
+				Data: data,
+			})
+			if apierrors.IsAlreadyExists(err) {
+				continue
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return renewed, nil
+	}
+	return nil, fmt.Errorf("unable to sync certificate secret %s: too many conflicts", key)
+}
+
+// renew returns the certificates to store in the Secret, or nil if certs can be kept as is.
+// New certificates are created when certs are missing, invalid or expire within RotateBefore,
+// otherwise expired CAs are dropped from the bundle.
+func (m *Manager) renew(certs *certificates, now time.Time) (*certificates, error) {
+	if certs == nil || !m.valid(certs, now) {
+		if certs != nil {
+			log.Info("rotating certificate", "notAfter", certs.server.cert.NotAfter)
+		}
+		ca, server, err := m.createCert()
+		if err != nil {
+			return nil, err
+		}
+		bundle := []*x509.Certificate{ca.cert}
+		if certs != nil {
+			bundle = append(bundle, unexpired(certs.caBundle, now)...)
+		}
+		log.Info("created certificate", "notAfter", server.cert.NotAfter)
+		return &certificates{ca: ca, server: server, caBundle: bundle}, nil
+	}
+
+	bundle := unexpired(certs.caBundle, now)
+	if len(bundle) == len(certs.caBundle) {
+		return nil, nil
+	}
+	return &certificates{ca: certs.ca, server: certs.server, caBundle: bundle}, nil
+}
+
+// valid reports whether the server certificate is signed by the CA, covers the DNS names
+// of the webhook and does not expire within RotateBefore.
+func (m *Manager) valid(certs *certificates, now time.Time) bool {
+	if certs.server.cert.NotAfter.Sub(now) < m.RotateBefore {
+		return false
+	}
+	if err := certs.server.cert.CheckSignatureFrom(certs.ca.cert); err != nil {
+		return false
+	}
+	for _, name := range m.dnsNames {
+		if err := certs.server.cert.VerifyHostname(name); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCertificates decodes the certificates stored in the data of the Secret.
+func parseCertificates(data map[string][]byte) (*certificates, error) {
+	bundle, err := parseCerts(data[_caCertKey])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", _caCertKey, err)
+	}
+	caKey, err := parseKey(data[_caKeyKey])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", _caKeyKey, err)
+	}
+	serverCerts, err := parseCerts(data[_serverCertKey])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", _serverCertKey, err)
+	}
+	serverKey, err := parseKey(data[_serverKeyKey])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", _serverKeyKey, err)
+	}
+	if !caKey.PublicKey.Equal(bundle[0].PublicKey) {
+		return nil, fmt.Errorf("%s does not match %s", _caKeyKey, _caCertKey)
+	}
+	if !serverKey.PublicKey.Equal(serverCerts[0].PublicKey) {
+		return nil, fmt.Errorf("%s does not match %s", _serverKeyKey, _serverCertKey)
+	}
+	return &certificates{
+		ca:       &keyPair{cert: bundle[0], key: caKey},
+		server:   &keyPair{cert: serverCerts[0], key: serverKey},
+		caBundle: bundle,
+	}, nil
+}
+
+// encode encodes the certificates as the data of the Secret.
+func (c *certificates) encode() (map[string][]byte, error) {
+	caPEM, err := encodeCerts(c.caBundle)
+	if err != nil {
+		return nil, err
+	}
+	caKeyPEM, err := encodeKey(c.ca.key)
+	if err != nil {
+		return nil, err
+	}
+	serverCertPEM, err := encodeCert(c.server.cert)
+	if err != nil {
+		return nil, err
+	}
+	serverKeyPEM, err := encodeKey(c.server.key)
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{
+		_caCertKey:     caPEM.Bytes(),
+		_caKeyKey:      caKeyPEM.Bytes(),
+		_serverCertKey: serverCertPEM.Bytes(),
+		_serverKeyKey:  serverKeyPEM.Bytes(),
+	}, nil
+}
+
+// encodeCerts encodes certs as a PEM bundle.
+func encodeCerts(certs []*x509.Certificate) (*bytes.Buffer, error) {
+	caPEM := new(bytes.Buffer)
+	for _, ca := range certs {
+		err := pem.Encode(caPEM, &pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: ca.Raw,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return caPEM, nil
+}
+
+func parseCerts(data []byte) ([]*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, 0)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	return certs, nil
+}
+
+func parseKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no private key found")
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+func encodeCert(cert *x509.Certificate) (*bytes.Buffer, error) {
+	certPEM := new(bytes.Buffer)
+	err := pem.Encode(certPEM, &pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: cert.Raw,
+	})
+	return certPEM, err
+}
+
+func encodeKey(key *rsa.PrivateKey) (*bytes.Buffer, error) {
+	keyPEM := new(bytes.Buffer)
+	err := pem.Encode(keyPEM, &pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+	return keyPEM, err
+}
+
+func equalCerts(a, b []*x509.Certificate) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
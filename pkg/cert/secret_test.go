@@ -0,0 +1,61 @@
+package cert
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func TestParseCertificates(t *testing.T) {
+	current, previous := newTestCertificates(t)
+	certs := &certificates{
+		ca:       current.ca,
+		server:   current.server,
+		caBundle: []*x509.Certificate{current.ca.cert, previous.ca.cert},
+	}
+	data, err := certs.encode()
+	if err != nil {
+		t.Fatalf("encode() error = %v", err)
+	}
+
+	got, err := parseCertificates(data)
+	if err != nil {
+		t.Fatalf("parseCertificates() error = %v", err)
+	}
+	if !got.ca.cert.Equal(certs.ca.cert) || !got.server.cert.Equal(certs.server.cert) || !equalCerts(got.caBundle, certs.caBundle) {
+		t.Error("parseCertificates() does not return the encoded certificates")
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(map[string][]byte)
+	}{
+		{
+			name:   "missing CA",
+			mutate: func(data map[string][]byte) { delete(data, _caCertKey) },
+		},
+		{
+			name:   "missing server key",
+			mutate: func(data map[string][]byte) { delete(data, _serverKeyKey) },
+		},
+		{
+			name:   "CA key of the server",
+			mutate: func(data map[string][]byte) { data[_caKeyKey] = data[_serverKeyKey] },
+		},
+		{
+			name:   "server key of the CA",
+			mutate: func(data map[string][]byte) { data[_serverKeyKey] = data[_caKeyKey] },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invalid := make(map[string][]byte, len(data))
+			for key, value := range data {
+				invalid[key] = value
+			}
+			tt.mutate(invalid)
+			if _, err := parseCertificates(invalid); err == nil {
+				t.Error("parseCertificates() error = nil, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package cert
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+
+	"github.com/togettoyou/sidecar-go/api/v1alpha1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// IsWebhookConfiguration reports whether name is one of the webhook configurations kept up to date by the Manager.
+func (m *Manager) IsWebhookConfiguration(name string) bool {
+	return name == m.MutatingWebhookConfigurationName || name == m.ValidatingWebhookConfigurationName
+}
+
+// ApplyWebhookConfigurations creates the webhook configurations, or patches them in place
+// when their CA bundle, annotations, rules or namespace selector drifted from the desired ones.
+// The current configurations are read from reader.
+// The certificates are loaded from the Provider rather than taken from the last rotation of this replica,
+// so that the leader never reverts the CA bundle to a stale one after another replica rotated it.
+func (m *Manager) ApplyWebhookConfigurations(ctx context.Context, reader client.Reader) error {
+	certs, err := m.Provider.Load(ctx, m)
+	if err != nil {
+		return err
+	}
+	return m.applyWebhookConfigurations(ctx, reader, certs)
+}
+
+// applyWebhookConfigurations applies the webhook configurations for the given certificates.
+func (m *Manager) applyWebhookConfigurations(ctx context.Context, reader client.Reader, certs *Certificates) error {
+	caPEM, err := encodeCerts(certs.CABundle)
+	if err != nil {
+		return err
+	}
+
+	mutatingWebhookConfig, err := m.mutatingWebhookConfiguration(caPEM)
+	if err != nil {
+		return err
+	}
+	mutatingWebhookConfig.Annotations = certs.Annotations
+	err = m.apply(ctx, reader, mutatingWebhookConfig, &admissionregistrationv1.MutatingWebhookConfiguration{}, func(obj client.Object) bool {
+		current := obj.(*admissionregistrationv1.MutatingWebhookConfiguration)
+		webhooks := mutatingWebhookConfig.Webhooks
+		if certs.CAInjected {
+			for i := range webhooks {
+				if i < len(current.Webhooks) && len(current.Webhooks[i].ClientConfig.CABundle) > 0 {
+					webhooks[i].ClientConfig.CABundle = current.Webhooks[i].ClientConfig.CABundle
+				}
+			}
+		}
+		changed := setAnnotations(current, certs.Annotations)
+		if equality.Semantic.DeepEqual(current.Webhooks, webhooks) {
+			return changed
+		}
+		current.Webhooks = webhooks
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	validatingWebhookConfig, err := m.validatingWebhookConfiguration(caPEM)
+	if err != nil {
+		return err
+	}
+	validatingWebhookConfig.Annotations = certs.Annotations
+	return m.apply(ctx, reader, validatingWebhookConfig, &admissionregistrationv1.ValidatingWebhookConfiguration{}, func(obj client.Object) bool {
+		current := obj.(*admissionregistrationv1.ValidatingWebhookConfiguration)
+		webhooks := validatingWebhookConfig.Webhooks
+		if certs.CAInjected {
+			for i := range webhooks {
+				if i < len(current.Webhooks) && len(current.Webhooks[i].ClientConfig.CABundle) > 0 {
+					webhooks[i].ClientConfig.CABundle = current.Webhooks[i].ClientConfig.CABundle
+				}
+			}
+		}
+		changed := setAnnotations(current, certs.Annotations)
+		if equality.Semantic.DeepEqual(current.Webhooks, webhooks) {
+			return changed
+		}
+		current.Webhooks = webhooks
+		return true
+	})
+}
+
+// setAnnotations sets annotations on obj, keeping its other annotations, and reports whether obj changed.
+func setAnnotations(obj client.Object, annotations map[string]string) bool {
+	current := obj.GetAnnotations()
+	changed := false
+	for key, value := range annotations {
+		if existing, ok := current[key]; ok && existing == value {
+			continue
+		}
+		if current == nil {
+			current = make(map[string]string)
+		}
+		current[key] = value
+		changed = true
+	}
+	obj.SetAnnotations(current)
+	return changed
+}
+
+// apply creates desired if it does not exist, otherwise it merge-patches current
+// when mutate reports a change.
+func (m *Manager) apply(ctx context.Context, reader client.Reader, desired, current client.Object, mutate func(client.Object) bool) error {
+	err := reader.Get(ctx, types.NamespacedName{Name: desired.GetName()}, current)
+	if apierrors.IsNotFound(err) {
+		log.Info("creating webhook configuration", "name", desired.GetName())
+		return m.Client.Create(ctx, desired)
+	}
+	if err != nil {
+		return err
+	}
+
+	patch := client.MergeFrom(current.DeepCopyObject().(client.Object))
+	if !mutate(current) {
+		return nil
+	}
+	log.Info("patching webhook configuration", "name", desired.GetName())
+	return m.Client.Patch(ctx, current, patch)
+}
+
+// clientConfig returns the client config of the webhook served at path.
+func (m *Manager) clientConfig(caPEM *bytes.Buffer, path string) (admissionregistrationv1.WebhookClientConfig, error) {
+	clientConfig := admissionregistrationv1.WebhookClientConfig{
+		CABundle: caPEM.Bytes(),
+	}
+	if m.WebhookURL != "" {
+		u, err := url.Parse(m.WebhookURL)
+		if err != nil {
+			return clientConfig, err
+		}
+		u.Path = path
+		webhookURL := u.String()
+		clientConfig.URL = &webhookURL
+	} else {
+		port := int32(443)
+		clientConfig.Service = &admissionregistrationv1.ServiceReference{
+			Name:      m.ServiceName,
+			Namespace: m.Namespace,
+			Path:      &path,
+			Port:      &port,
+		}
+	}
+	return clientConfig, nil
+}
+
+// mutatingWebhookConfiguration returns the desired pod webhook configuration.
+// Fields defaulted by the API server are set explicitly, so that the desired
+// webhooks compare equal to the stored ones.
+func (m *Manager) mutatingWebhookConfiguration(caPEM *bytes.Buffer) (*admissionregistrationv1.MutatingWebhookConfiguration, error) {
+	clientConfig, err := m.clientConfig(caPEM, m.WebhookInjectPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: m.MutatingWebhookConfigurationName,
+		},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
+			Name:                    _webhookName,
+			AdmissionReviewVersions: []string{"v1"},
+			SideEffects: func() *admissionregistrationv1.SideEffectClass {
+				se := admissionregistrationv1.SideEffectClassNone
+				return &se
+			}(),
+			ClientConfig: clientConfig,
+			Rules: []admissionregistrationv1.RuleWithOperations{
+				{
+					Operations: []admissionregistrationv1.OperationType{
+						admissionregistrationv1.Create,
+					},
+					Rule: admissionregistrationv1.Rule{
+						APIGroups:   []string{""},
+						APIVersions: []string{"v1"},
+						Resources:   []string{"pods"},
+						Scope:       scope(admissionregistrationv1.AllScopes),
+					},
+				},
+			},
+			FailurePolicy: func() *admissionregistrationv1.FailurePolicyType {
+				pt := admissionregistrationv1.Ignore
+				return &pt
+			}(),
+			MatchPolicy: matchPolicy(admissionregistrationv1.Equivalent),
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{
+						Key:      "kubernetes.io/metadata.name",
+						Operator: metav1.LabelSelectorOpNotIn,
+						Values:   m.excludedNamespaces(),
+					},
+				},
+			},
+			ObjectSelector: &metav1.LabelSelector{},
+			ReinvocationPolicy: func() *admissionregistrationv1.ReinvocationPolicyType {
+				rp := admissionregistrationv1.NeverReinvocationPolicy
+				return &rp
+			}(),
+			TimeoutSeconds: timeoutSeconds(10),
+		}},
+	}, nil
+}
+
+// validatingWebhookConfiguration returns the desired SidecarGo webhook configuration.
+func (m *Manager) validatingWebhookConfiguration(caPEM *bytes.Buffer) (*admissionregistrationv1.ValidatingWebhookConfiguration, error) {
+	clientConfig, err := m.clientConfig(caPEM, m.WebhookValidatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: m.ValidatingWebhookConfigurationName,
+		},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{{
+			Name:                    _validatingWebhookName,
+			AdmissionReviewVersions: []string{"v1"},
+			SideEffects: func() *admissionregistrationv1.SideEffectClass {
+				se := admissionregistrationv1.SideEffectClassNone
+				return &se
+			}(),
+			ClientConfig: clientConfig,
+			Rules: []admissionregistrationv1.RuleWithOperations{
+				{
+					Operations: []admissionregistrationv1.OperationType{
+						admissionregistrationv1.Create,
+						admissionregistrationv1.Update,
+					},
+					Rule: admissionregistrationv1.Rule{
+						APIGroups:   []string{"apps.togettoyou.com"},
+						APIVersions: []string{"v1alpha1"},
+						Resources:   []string{"sidecargoes", "clustersidecargoes"},
+						Scope:       scope(admissionregistrationv1.AllScopes),
+					},
+				},
+			},
+			FailurePolicy: func() *admissionregistrationv1.FailurePolicyType {
+				pt := admissionregistrationv1.Fail
+				return &pt
+			}(),
+			MatchPolicy:       matchPolicy(admissionregistrationv1.Equivalent),
+			NamespaceSelector: &metav1.LabelSelector{},
+			ObjectSelector:    m.classSelector(),
+			TimeoutSeconds:    timeoutSeconds(10),
+		}},
+	}, nil
+}
+
+// classSelector selects the SidecarGo labeled with the class of the installation, or without class label
+// if it has none, so that the failing webhook of an installation does not block the SidecarGo of the others.
+func (m *Manager) classSelector() *metav1.LabelSelector {
+	if m.Class == "" {
+		return &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{
+					Key:      v1alpha1.LabelClass,
+					Operator: metav1.LabelSelectorOpDoesNotExist,
+				},
+			},
+		}
+	}
+	return &metav1.LabelSelector{MatchLabels: map[string]string{v1alpha1.LabelClass: m.Class}}
+}
+
+// excludedNamespaces returns ExcludedNamespaces and Namespace, sorted so that
+// the namespace selector compares equal across replicas.
+func (m *Manager) excludedNamespaces() []string {
+	return sets.NewString(m.ExcludedNamespaces...).Insert(m.Namespace).List()
+}
+
+func scope(s admissionregistrationv1.ScopeType) *admissionregistrationv1.ScopeType {
+	return &s
+}
+
+func matchPolicy(mp admissionregistrationv1.MatchPolicyType) *admissionregistrationv1.MatchPolicyType {
+	return &mp
+}
+
+func timeoutSeconds(t int32) *int32 {
+	return &t
+}
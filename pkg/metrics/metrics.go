@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// LabelName and LabelNamespace identify the SidecarGo. They are empty for errors unrelated to a SidecarGo.
+	LabelName      = "sidecargo_name"
+	LabelNamespace = "sidecargo_namespace"
+	// LabelReason is the reason a matched SidecarGo was skipped.
+	LabelReason = "reason"
+)
+
+var (
+	// PodsEvaluated counts the pods handled by the pod webhook.
+	PodsEvaluated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sidecar_go_webhook_pods_evaluated_total",
+		Help: "Total number of pods evaluated by the pod webhook",
+	})
+	// PodsInjected counts the pods a SidecarGo was injected into.
+	PodsInjected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sidecar_go_webhook_pods_injected_total",
+		Help: "Total number of pods injected, by SidecarGo",
+	}, []string{LabelName, LabelNamespace})
+	// PodsSkipped counts the pods matching a SidecarGo that was not injected into them.
+	PodsSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sidecar_go_webhook_pods_skipped_total",
+		Help: "Total number of matched pods not injected, by SidecarGo and reason",
+	}, []string{LabelName, LabelNamespace, LabelReason})
+	// PodsErrored counts the pods denied because a SidecarGo cannot be injected, or failed to be handled.
+	PodsErrored = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sidecar_go_webhook_pods_errored_total",
+		Help: "Total number of pods denied or failed, by SidecarGo",
+	}, []string{LabelName, LabelNamespace})
+
+	// HandleDuration observes the latency of the pod webhook.
+	HandleDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "sidecar_go_webhook_duration_seconds",
+		Help:    "Latency of the pod webhook in seconds",
+		Buckets: []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+	})
+	// PatchSize observes the size of the JSON patches returned by the pod webhook.
+	PatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "sidecar_go_webhook_patch_size_bytes",
+		Help:    "Size of the JSON patches returned by the pod webhook in bytes",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+	})
+
+	// SpecsLoaded is the number of SidecarGo loaded for injection.
+	SpecsLoaded = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sidecar_go_specs_loaded",
+		Help: "Number of SidecarGo loaded for injection",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		PodsEvaluated,
+		PodsInjected,
+		PodsSkipped,
+		PodsErrored,
+		HandleDuration,
+		PatchSize,
+		SpecsLoaded,
+	)
+}
@@ -0,0 +1,136 @@
+// Package template renders the Go templates of templated SidecarGo.
+// It only depends on the standard library, so that the API package can validate templates too.
+package template
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	texttemplate "text/template"
+)
+
+// funcs are the functions templates may call besides the builtins of text/template.
+// They only transform strings, templates can not reach anything but the data they are rendered against.
+var funcs = texttemplate.FuncMap{
+	"default": func(def, value string) string {
+		if value == "" {
+			return def
+		}
+		return value
+	},
+	"required": func(what, value string) (string, error) {
+		if value == "" {
+			return "", fmt.Errorf("%s is required", what)
+		}
+		return value, nil
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string {
+		return strings.TrimPrefix(s, prefix)
+	},
+	"trimSuffix": func(suffix, s string) string {
+		return strings.TrimSuffix(s, suffix)
+	},
+	"replace": func(old, new, s string) string {
+		return strings.ReplaceAll(s, old, new)
+	},
+}
+
+// Error is an error of the template at Path, e.g. containers[0].args[1].
+type Error struct {
+	Path     string
+	Template string
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// IsTemplate reports whether s holds a template.
+func IsTemplate(s string) bool {
+	return strings.Contains(s, "{{")
+}
+
+// Parse parses a template. Missing map keys are errors when it is executed.
+func Parse(text string) (*texttemplate.Template, error) {
+	return texttemplate.New("").Funcs(funcs).Option("missingkey=error").Parse(text)
+}
+
+// Render renders the template against data.
+func Render(text string, data interface{}) (string, error) {
+	tmpl, err := Parse(text)
+	if err != nil {
+		return "", err
+	}
+	out := new(bytes.Buffer)
+	if err := tmpl.Execute(out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// Validate parses the templates among the string values of part, the field at path.
+func Validate(path string, part interface{}) error {
+	_, err := RenderJSON(path, part, func(text string) (string, error) {
+		_, err := Parse(text)
+		return text, err
+	})
+	return err
+}
+
+// RenderJSON passes the templates among the string values of part, the field at path, through render,
+// and returns the result as JSON. Errors are *Error.
+func RenderJSON(path string, part interface{}, render func(string) (string, error)) ([]byte, error) {
+	raw, err := json.Marshal(part)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+	value, err = renderValue(path, value, render)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+func renderValue(path string, value interface{}, render func(string) (string, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if !IsTemplate(v) {
+			return v, nil
+		}
+		rendered, err := render(v)
+		if err != nil {
+			return nil, &Error{Path: path, Template: v, Err: err}
+		}
+		return rendered, nil
+	case []interface{}:
+		for i := range v {
+			item, err := renderValue(fmt.Sprintf("%s[%d]", path, i), v[i], render)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = item
+		}
+	case map[string]interface{}:
+		for key := range v {
+			item, err := renderValue(path+"."+key, v[key], render)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = item
+		}
+	}
+	return value, nil
+}
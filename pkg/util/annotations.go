@@ -0,0 +1,64 @@
+package util
+
+const (
+	// AnnotationInject set to "false" on a pod disables the injection of all SidecarGo.
+	// Otherwise it lists requestable SidecarGo, by "name" or "namespace/name", to inject into the pod.
+	AnnotationInject = "sidecar-go.togettoyou.com/inject"
+	// AnnotationSkip lists SidecarGo, by "name" or "namespace/name", that must not be injected into the pod.
+	AnnotationSkip = "sidecar-go.togettoyou.com/skip"
+	// AnnotationSkipped records the matched SidecarGo that were not injected,
+	// formatted as "namespace/name=reason,...".
+	AnnotationSkipped = "sidecar-go.togettoyou.com/skipped"
+
+	// AnnotationInjected lists the SidecarGo objects injected into the pod.
+	AnnotationInjected = "sidecar-go.togettoyou.com/injected"
+	// AnnotationRevisions records the spec revision of every SidecarGo injected into the pod,
+	// formatted as "namespace/name=revision,...".
+	AnnotationRevisions = "sidecar-go.togettoyou.com/revisions"
+
+	// AnnotationRollout is set on the pod template of workloads restarted by a SidecarGo rollout.
+	// The value lists the SidecarGo revisions the workload was restarted for, formatted like AnnotationRevisions.
+	AnnotationRollout = "sidecar-go.togettoyou.com/rollout"
+	// AnnotationRestartedAt records the time a SidecarGo rollout restarted the workload.
+	AnnotationRestartedAt = "sidecar-go.togettoyou.com/restartedAt"
+)
+
+const (
+	// SkipReasonInjectDisabled means the pod disabled injection with AnnotationInject.
+	SkipReasonInjectDisabled = "InjectDisabled"
+	// SkipReasonSkipListed means the pod listed the SidecarGo in AnnotationSkip.
+	SkipReasonSkipListed = "SkipListed"
+	// SkipReasonAlreadyInjected means the pod already carries the SidecarGo. It is only reported by dry runs.
+	SkipReasonAlreadyInjected = "AlreadyInjected"
+)
+
+const (
+	// MismatchReasonNamespace means the pod is not in the namespace of the SidecarGo.
+	MismatchReasonNamespace = "NamespaceMismatch"
+	// MismatchReasonNamespaceSelector means the namespace of the pod does not match the namespaceSelector.
+	MismatchReasonNamespaceSelector = "NamespaceSelectorMismatch"
+	// MismatchReasonOwnerKind means the pod is not controlled by one of the ownerKinds.
+	MismatchReasonOwnerKind = "OwnerKindMismatch"
+	// MismatchReasonServiceAccount means the pod does not run as one of the serviceAccountNames.
+	MismatchReasonServiceAccount = "ServiceAccountMismatch"
+	// MismatchReasonPriorityClass means the pod is not of one of the priorityClassNames.
+	MismatchReasonPriorityClass = "PriorityClassMismatch"
+	// MismatchReasonAnnotationSelector means the annotations of the pod do not match the annotationSelector.
+	MismatchReasonAnnotationSelector = "AnnotationSelectorMismatch"
+	// MismatchReasonContainer means no container of the pod matches the containerSelector.
+	MismatchReasonContainer = "ContainerMismatch"
+	// MismatchReasonNodeSelector means the nodeSelector and nodeName of the pod do not match the nodeSelector.
+	MismatchReasonNodeSelector = "NodeSelectorMismatch"
+	// MismatchReasonSelector means the pod does not match the selector, or the selector is empty.
+	MismatchReasonSelector = "SelectorMismatch"
+	// MismatchReasonExcludeSelector means the pod matches but is excluded by the excludeSelector.
+	MismatchReasonExcludeSelector = "ExcludedBySelector"
+	// MismatchReasonExcludeNamespace means the pod matches but its namespace is in excludeNamespaces.
+	MismatchReasonExcludeNamespace = "ExcludedNamespace"
+	// MismatchReasonExcludedByInjector means the namespace of the pod is excluded from the pod webhook
+	// of the installation.
+	MismatchReasonExcludedByInjector = "ExcludedByInjector"
+	// MismatchReasonNoSelector means the SidecarGo has neither selector, namespace restriction nor pod matcher,
+	// so it matches no pod.
+	MismatchReasonNoSelector = "NoSelector"
+)
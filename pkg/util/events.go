@@ -0,0 +1,12 @@
+package util
+
+const (
+	// EventReasonInvalidSpec is recorded on a SidecarGo that cannot be loaded.
+	EventReasonInvalidSpec = "InvalidSpec"
+	// EventReasonContainerConflict is recorded on a SidecarGo injecting containers also injected by another one.
+	EventReasonContainerConflict = "ContainerConflict"
+	// EventReasonInjected is recorded on the owner of a pod that sidecars were injected into.
+	EventReasonInjected = "SidecarsInjected"
+	// EventReasonInjectionDenied is recorded on the owner of a pod denied because a SidecarGo cannot be injected.
+	EventReasonInjectionDenied = "InjectionDenied"
+)
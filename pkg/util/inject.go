@@ -0,0 +1,173 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/togettoyou/sidecar-go/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// InjectResult is the outcome of injecting the loaded SidecarGo into a pod.
+type InjectResult struct {
+	// Injected are the SidecarGo injected into the pod, in injection order.
+	Injected []MatchedSidecarGo
+	// Skipped are the SidecarGo matching the pod that were not injected, with the reason.
+	Skipped map[string]string
+	// Mismatched are the loaded SidecarGo not matching the pod, with the reason.
+	Mismatched map[string]string
+	// Pod is the mutated raw pod, or nil if no SidecarGo matched the pod.
+	Pod []byte
+}
+
+// InjectError is a SidecarGo that cannot be injected into the pod, which denies the pod.
+type InjectError struct {
+	NamespacedName string
+	Err            error
+}
+
+func (e *InjectError) Error() string {
+	return fmt.Sprintf("SidecarGo %s: %v", e.NamespacedName, e.Err)
+}
+
+func (e *InjectError) Unwrap() error {
+	return e.Err
+}
+
+// Inject injects the SidecarGo of the store matching the pod in the given namespace.
+// rawPod is the pod as received and pod is its decoded form, which is mutated.
+// The pod webhook and dry runs both inject through Inject, so that they always agree.
+// An *InjectError is returned with the result, which then has no Pod.
+func (s *SpecStore) Inject(ctx context.Context, rawPod []byte, pod *corev1.Pod, namespace *corev1.Namespace, nativeSidecars bool) (*InjectResult, error) {
+	matched, mismatched := s.PodMatchReasons(ctx, pod, namespace)
+	result := &InjectResult{Skipped: make(map[string]string), Mismatched: mismatched}
+	// requested SidecarGo that can not be injected deny the pod
+	if errs := s.PodRequestErrors(ctx, pod, namespace); len(errs) > 0 {
+		return result, errs[0]
+	}
+	if len(matched) == 0 {
+		return result, nil
+	}
+
+	// 0.drop the SidecarGo the pod opted out of or already carries
+	injected := FilterSkipped(pod, matched)
+	injected = FilterInjected(pod, injected)
+	injectedNames := sets.NewString()
+	for _, m := range injected {
+		injectedNames.Insert(m.NamespacedName)
+	}
+	for _, m := range matched {
+		if injectedNames.Has(m.NamespacedName) {
+			continue
+		}
+		reason := SkipReason(m.NamespacedName, pod)
+		if reason == "" {
+			reason = SkipReasonAlreadyInjected
+		}
+		result.Skipped[m.NamespacedName] = reason
+	}
+
+	appContainers := sets.NewString()
+	for _, container := range pod.Spec.Containers {
+		appContainers.Insert(container.Name)
+	}
+	restartPolicies, err := InitContainerRestartPolicies(rawPod)
+	if err != nil {
+		return nil, err
+	}
+	// templates are rendered against the pod as received
+	data := NewTemplateData(pod, namespace)
+	result.Injected = injected
+	for _, m := range injected {
+		if err := injectSidecarGo(pod, m, data, appContainers, restartPolicies, nativeSidecars); err != nil {
+			return result, &InjectError{NamespacedName: m.NamespacedName, Err: err}
+		}
+	}
+	// 5.record injected revisions
+	if len(injected) > 0 {
+		SetInjectedAnnotations(pod, injected)
+	}
+
+	marshaledPod, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+	if len(restartPolicies) > 0 {
+		marshaledPod, err = SetInitContainerRestartPolicies(marshaledPod, restartPolicies)
+		if err != nil {
+			return nil, err
+		}
+	}
+	result.Pod = marshaledPod
+	return result, nil
+}
+
+func injectSidecarGo(pod *corev1.Pod, m MatchedSidecarGo, data *TemplateData, appContainers sets.String, restartPolicies map[string]string, nativeSidecars bool) error {
+	spec, err := RenderSpec(m.Spec, data)
+	if err != nil {
+		return err
+	}
+	policy := spec.MergePolicy
+	// 1.patch the containers of the pod itself
+	err = PatchContainers(pod.Spec.Containers, appContainers, spec.ContainerPatches)
+	if err != nil {
+		return err
+	}
+	// 2.inject init containers
+	initContainers, err := ExcludeContainerNames(spec.InitContainers, pod.Spec.Containers, "containers", policy)
+	if err != nil {
+		return err
+	}
+	existing := containerNames(pod.Spec.InitContainers)
+	pod.Spec.InitContainers, err = MergeContainers(pod.Spec.InitContainers, initContainers, policy)
+	if err != nil {
+		return err
+	}
+	// init containers replacing or added to the init containers are ordinary ones, even if they replace a native sidecar
+	for _, container := range initContainers {
+		if !existing.Has(container.Name) || overwrites(policy) {
+			delete(restartPolicies, container.Name)
+		}
+	}
+	// 3.inject containers, as native sidecars if supported
+	if spec.SidecarMode == v1alpha1.SidecarModeNative && nativeSidecars {
+		containers, err := ExcludeContainerNames(spec.Containers, pod.Spec.Containers, "containers", policy)
+		if err != nil {
+			return err
+		}
+		// only the sidecars replacing or added to the init containers run as native sidecars,
+		// init containers of the pod kept or merged into keep their restart policy
+		existing = containerNames(pod.Spec.InitContainers)
+		pod.Spec.InitContainers, err = MergeContainers(pod.Spec.InitContainers, containers, policy)
+		if err != nil {
+			return err
+		}
+		for _, container := range containers {
+			if !existing.Has(container.Name) || overwrites(policy) {
+				restartPolicies[container.Name] = ContainerRestartPolicyAlways
+			}
+		}
+	} else {
+		containers, err := ExcludeContainerNames(spec.Containers, pod.Spec.InitContainers, "initContainers", policy)
+		if err != nil {
+			return err
+		}
+		pod.Spec.Containers, err = MergeContainers(pod.Spec.Containers, containers, policy)
+		if err != nil {
+			return err
+		}
+	}
+	// 4.inject volumes
+	pod.Spec.Volumes, err = MergeVolumes(pod.Spec.Volumes, spec.Volumes, policy)
+	return err
+}
+
+func containerNames(containers []corev1.Container) sets.String {
+	names := sets.NewString()
+	for _, container := range containers {
+		names.Insert(container.Name)
+	}
+	return names
+}
@@ -0,0 +1,204 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/togettoyou/sidecar-go/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestInject(t *testing.T) {
+	webSelector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+	sidecars := func(mode v1alpha1.SidecarMode, policy v1alpha1.MergePolicy, names ...string) v1alpha1.SidecarGoSpec {
+		spec := v1alpha1.SidecarGoSpec{Selector: webSelector, SidecarMode: mode, MergePolicy: policy}
+		for _, name := range names {
+			spec.Containers = append(spec.Containers, corev1.Container{Name: name, Image: name + ":v2"})
+		}
+		return spec
+	}
+	initContainers := func(policy v1alpha1.MergePolicy, names ...string) v1alpha1.SidecarGoSpec {
+		spec := v1alpha1.SidecarGoSpec{Selector: webSelector, MergePolicy: policy}
+		for _, name := range names {
+			spec.InitContainers = append(spec.InitContainers, corev1.Container{Name: name, Image: name + ":v2"})
+		}
+		return spec
+	}
+
+	tests := []struct {
+		name string
+		// specs are the loaded SidecarGo by name, injected in name order.
+		specs  map[string]v1alpha1.SidecarGoSpec
+		rawPod string
+		native bool
+		// want are the names of the containers and init containers of the injected pod,
+		// with the restartPolicy of the init containers after a colon.
+		wantContainers     []string
+		wantInitContainers []string
+		wantErr            string
+	}{
+		{
+			name:               "containers",
+			specs:              map[string]v1alpha1.SidecarGoSpec{"a": sidecars(v1alpha1.SidecarModeContainer, "", "proxy")},
+			rawPod:             `{"spec":{"containers":[{"name":"web","image":"web"}]}}`,
+			native:             true,
+			wantContainers:     []string{"web", "proxy"},
+			wantInitContainers: []string{},
+		},
+		{
+			name:               "native sidecars",
+			specs:              map[string]v1alpha1.SidecarGoSpec{"a": sidecars(v1alpha1.SidecarModeNative, "", "proxy")},
+			rawPod:             `{"spec":{"initContainers":[{"name":"setup","image":"setup"}],"containers":[{"name":"web","image":"web"}]}}`,
+			native:             true,
+			wantContainers:     []string{"web"},
+			wantInitContainers: []string{"setup", "proxy:Always"},
+		},
+		{
+			name:               "native sidecars unsupported",
+			specs:              map[string]v1alpha1.SidecarGoSpec{"a": sidecars(v1alpha1.SidecarModeNative, "", "proxy")},
+			rawPod:             `{"spec":{"containers":[{"name":"web","image":"web"}]}}`,
+			wantContainers:     []string{"web", "proxy"},
+			wantInitContainers: []string{},
+		},
+		{
+			name:               "native sidecars of the pod are kept",
+			specs:              map[string]v1alpha1.SidecarGoSpec{"a": initContainers("", "setup")},
+			rawPod:             `{"spec":{"initContainers":[{"name":"mesh","image":"mesh","restartPolicy":"Always"}],"containers":[{"name":"web","image":"web"}]}}`,
+			native:             true,
+			wantContainers:     []string{"web"},
+			wantInitContainers: []string{"mesh:Always", "setup"},
+		},
+		{
+			name:               "init container overwriting a native sidecar of the pod",
+			specs:              map[string]v1alpha1.SidecarGoSpec{"a": initContainers(v1alpha1.MergePolicyOverwrite, "mesh")},
+			rawPod:             `{"spec":{"initContainers":[{"name":"mesh","image":"mesh","restartPolicy":"Always"}],"containers":[{"name":"web","image":"web"}]}}`,
+			native:             true,
+			wantContainers:     []string{"web"},
+			wantInitContainers: []string{"mesh"},
+		},
+		{
+			name:               "init container merged into a native sidecar of the pod",
+			specs:              map[string]v1alpha1.SidecarGoSpec{"a": initContainers(v1alpha1.MergePolicyMerge, "mesh")},
+			rawPod:             `{"spec":{"initContainers":[{"name":"mesh","image":"mesh","restartPolicy":"Always"}],"containers":[{"name":"web","image":"web"}]}}`,
+			native:             true,
+			wantContainers:     []string{"web"},
+			wantInitContainers: []string{"mesh:Always"},
+		},
+		{
+			name:               "native sidecar overwriting an init container of the pod",
+			specs:              map[string]v1alpha1.SidecarGoSpec{"a": sidecars(v1alpha1.SidecarModeNative, v1alpha1.MergePolicyOverwrite, "setup")},
+			rawPod:             `{"spec":{"initContainers":[{"name":"setup","image":"setup"}],"containers":[{"name":"web","image":"web"}]}}`,
+			native:             true,
+			wantContainers:     []string{"web"},
+			wantInitContainers: []string{"setup:Always"},
+		},
+		{
+			name:               "native sidecar skipping an init container of the pod",
+			specs:              map[string]v1alpha1.SidecarGoSpec{"a": sidecars(v1alpha1.SidecarModeNative, v1alpha1.MergePolicySkip, "setup")},
+			rawPod:             `{"spec":{"initContainers":[{"name":"setup","image":"setup"}],"containers":[{"name":"web","image":"web"}]}}`,
+			native:             true,
+			wantContainers:     []string{"web"},
+			wantInitContainers: []string{"setup"},
+		},
+		{
+			name: "init container of a later SidecarGo overwriting a native sidecar",
+			specs: map[string]v1alpha1.SidecarGoSpec{
+				"a": sidecars(v1alpha1.SidecarModeNative, "", "proxy"),
+				"b": initContainers(v1alpha1.MergePolicyOverwrite, "proxy"),
+			},
+			rawPod:             `{"spec":{"containers":[{"name":"web","image":"web"}]}}`,
+			native:             true,
+			wantContainers:     []string{"web"},
+			wantInitContainers: []string{"proxy"},
+		},
+		{
+			name:    "native sidecar named after an app container",
+			specs:   map[string]v1alpha1.SidecarGoSpec{"a": sidecars(v1alpha1.SidecarModeNative, v1alpha1.MergePolicyOverwrite, "web")},
+			rawPod:  `{"spec":{"containers":[{"name":"web","image":"web"}]}}`,
+			native:  true,
+			wantErr: `container "web" already exists in the containers of the pod`,
+		},
+		{
+			name:               "container named after an init container is skipped",
+			specs:              map[string]v1alpha1.SidecarGoSpec{"a": sidecars(v1alpha1.SidecarModeContainer, v1alpha1.MergePolicySkip, "setup", "proxy")},
+			rawPod:             `{"spec":{"initContainers":[{"name":"setup","image":"setup"}],"containers":[{"name":"web","image":"web"}]}}`,
+			wantContainers:     []string{"web", "proxy"},
+			wantInitContainers: []string{"setup"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSpecStore(nil, nil, "")
+			for name, spec := range tt.specs {
+				spec := spec
+				if err := s.Update(name, &spec); err != nil {
+					t.Fatalf("Update() error = %v", err)
+				}
+			}
+			pod := &corev1.Pod{}
+			if err := json.Unmarshal([]byte(tt.rawPod), pod); err != nil {
+				t.Fatal(err)
+			}
+			pod.Labels = map[string]string{"app": "web"}
+			rawPod, err := json.Marshal(pod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// keep the restartPolicy the vendored types drop
+			rawPod, err = SetInitContainerRestartPolicies(rawPod, mustRestartPolicies(t, tt.rawPod))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := s.Inject(context.Background(), rawPod, pod, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}, tt.native)
+			if tt.wantErr != "" {
+				var injectErr *InjectError
+				if !errors.As(err, &injectErr) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Inject() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Inject() error = %v", err)
+			}
+
+			injected := &corev1.Pod{}
+			if err := json.Unmarshal(result.Pod, injected); err != nil {
+				t.Fatal(err)
+			}
+			containers := make([]string, 0)
+			for _, container := range injected.Spec.Containers {
+				containers = append(containers, container.Name)
+			}
+			policies := mustRestartPolicies(t, string(result.Pod))
+			initContainers := make([]string, 0)
+			for _, container := range injected.Spec.InitContainers {
+				name := container.Name
+				if policy, ok := policies[name]; ok {
+					name += ":" + policy
+				}
+				initContainers = append(initContainers, name)
+			}
+			if !equality.Semantic.DeepEqual(containers, tt.wantContainers) {
+				t.Errorf("Inject() containers = %v, want %v", containers, tt.wantContainers)
+			}
+			if !equality.Semantic.DeepEqual(initContainers, tt.wantInitContainers) {
+				t.Errorf("Inject() init containers = %v, want %v", initContainers, tt.wantInitContainers)
+			}
+		})
+	}
+}
+
+func mustRestartPolicies(t *testing.T, rawPod string) map[string]string {
+	t.Helper()
+	policies, err := InitContainerRestartPolicies([]byte(rawPod))
+	if err != nil {
+		t.Fatalf("InitContainerRestartPolicies() error = %v", err)
+	}
+	return policies
+}
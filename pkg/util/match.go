@@ -0,0 +1,133 @@
+package util
+
+import (
+	"context"
+
+	"github.com/togettoyou/sidecar-go/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PodOwnerKind returns the kind of the workload controlling the pod, or "" if it has no controller.
+// Pods of a ReplicaSet or Job are controlled by the Deployment or CronJob owning it, if any.
+// The owner is read from reader, or from apiReader if reader does not have it yet, as is the case
+// of a cache for the owners created right before their pods.
+// The kind of the direct controller is returned with the error if the owner can not be read.
+func PodOwnerKind(ctx context.Context, reader, apiReader client.Reader, pod *corev1.Pod, namespace string) (string, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return "", nil
+	}
+	var obj client.Object
+	switch owner.Kind {
+	case "ReplicaSet":
+		obj = &appsv1.ReplicaSet{}
+	case "Job":
+		obj = &batchv1.Job{}
+	default:
+		return owner.Kind, nil
+	}
+	key := types.NamespacedName{Namespace: namespace, Name: owner.Name}
+	err := reader.Get(ctx, key, obj)
+	if apierrors.IsNotFound(err) && apiReader != nil {
+		err = apiReader.Get(ctx, key, obj)
+	}
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return owner.Kind, nil
+		}
+		return owner.Kind, err
+	}
+	if workload := metav1.GetControllerOf(obj); workload != nil {
+		return workload.Kind, nil
+	}
+	return owner.Kind, nil
+}
+
+// PodHasContainer reports whether a container of the pod matches the name and image patterns of the selector.
+func PodHasContainer(pod *corev1.Pod, selector *v1alpha1.ContainerSelector) bool {
+	for _, container := range pod.Spec.Containers {
+		// invalid patterns are rejected by the validating webhook
+		nameMatched, _ := ContainerSelected(container.Name, selector.Names)
+		imageMatched, _ := ContainerSelected(container.Image, selector.Images)
+		if nameMatched && imageMatched {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPodMatchers reports whether the spec restricts the pods it injects beyond namespaces and the pod selector.
+func hasPodMatchers(spec *v1alpha1.SidecarGoSpec) bool {
+	return len(spec.OwnerKinds) > 0 || len(spec.ServiceAccountNames) > 0 || len(spec.PriorityClassNames) > 0 ||
+		spec.AnnotationSelector != nil || spec.ContainerSelector != nil || spec.NodeSelector != nil
+}
+
+// PodNodeLabels returns the node labels the pod requires: its nodeSelector,
+// and its nodeName as the kubernetes.io/hostname label.
+func PodNodeLabels(pod *corev1.Pod) labels.Set {
+	nodeLabels := labels.Set{}
+	for key, value := range pod.Spec.NodeSelector {
+		nodeLabels[key] = value
+	}
+	if pod.Spec.NodeName != "" {
+		nodeLabels[corev1.LabelHostname] = pod.Spec.NodeName
+	}
+	return nodeLabels
+}
+
+// podMatcherMismatchReason returns why the pod does not match the pod matchers of the spec, or "" if it matches.
+// ownerKind is the kind of the workload controlling the pod.
+func (s *SpecStore) podMatcherMismatchReason(namespacedName string, spec *v1alpha1.SidecarGoSpec, pod *corev1.Pod, ownerKind string) string {
+	if len(spec.OwnerKinds) > 0 && !sets.NewString(spec.OwnerKinds...).Has(ownerKind) {
+		return MismatchReasonOwnerKind
+	}
+	serviceAccountName := pod.Spec.ServiceAccountName
+	if serviceAccountName == "" {
+		serviceAccountName = "default"
+	}
+	if len(spec.ServiceAccountNames) > 0 && !sets.NewString(spec.ServiceAccountNames...).Has(serviceAccountName) {
+		return MismatchReasonServiceAccount
+	}
+	if len(spec.PriorityClassNames) > 0 && !sets.NewString(spec.PriorityClassNames...).Has(pod.Spec.PriorityClassName) {
+		return MismatchReasonPriorityClass
+	}
+	if selector, ok := s.annotationSelectors[namespacedName]; ok && !selector.Matches(labels.Set(pod.Annotations)) {
+		return MismatchReasonAnnotationSelector
+	}
+	if spec.ContainerSelector != nil && !PodHasContainer(pod, spec.ContainerSelector) {
+		return MismatchReasonContainer
+	}
+	if selector, ok := s.nodeSelectors[namespacedName]; ok && !selector.Matches(PodNodeLabels(pod)) {
+		return MismatchReasonNodeSelector
+	}
+	return ""
+}
+
+// podOwnerKind resolves the kind of the workload controlling the pod when a loaded SidecarGo matches on it.
+func (s *SpecStore) podOwnerKind(ctx context.Context, pod *corev1.Pod, namespace *corev1.Namespace) string {
+	s.mu.RLock()
+	needed := false
+	for _, spec := range s.specs {
+		if len(spec.OwnerKinds) > 0 {
+			needed = true
+			break
+		}
+	}
+	s.mu.RUnlock()
+	if !needed {
+		return ""
+	}
+	ownerKind, err := PodOwnerKind(ctx, s.Cache, s.APIReader, pod, namespace.Name)
+	if err != nil {
+		storelog.Error(err, "unable to resolve the owner of the pod, matching its controller kind", "namespace", namespace.Name, "pod", pod.Name)
+	}
+	return ownerKind
+}
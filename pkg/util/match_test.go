@@ -0,0 +1,118 @@
+package util
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPodOwnerKind(t *testing.T) {
+	controlledBy := func(kind, name string) metav1.ObjectMeta {
+		controller := true
+		return metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "default",
+			OwnerReferences: []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}},
+		}
+	}
+	replicaSet := &appsv1.ReplicaSet{ObjectMeta: controlledBy("Deployment", "web")}
+	replicaSet.Name = "web-6d4cf56db6"
+	job := &batchv1.Job{ObjectMeta: controlledBy("CronJob", "backup")}
+	job.Name = "backup-27800000"
+	orphan := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "orphan", Namespace: "default"}}
+	newClient := func(objs ...client.Object) client.Client {
+		return fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(objs...).Build()
+	}
+
+	tests := []struct {
+		name string
+		// ownerKind and ownerName are the controller of the pod.
+		ownerKind string
+		ownerName string
+		reader    client.Reader
+		apiReader client.Reader
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:   "no controller",
+			reader: newClient(),
+			want:   "",
+		},
+		{
+			name:      "controlled by a workload",
+			ownerKind: "StatefulSet",
+			ownerName: "db",
+			reader:    newClient(),
+			want:      "StatefulSet",
+		},
+		{
+			name:      "ReplicaSet of a Deployment",
+			ownerKind: "ReplicaSet",
+			ownerName: replicaSet.Name,
+			reader:    newClient(replicaSet),
+			want:      "Deployment",
+		},
+		{
+			name:      "ReplicaSet missing from the cache",
+			ownerKind: "ReplicaSet",
+			ownerName: replicaSet.Name,
+			reader:    newClient(),
+			apiReader: newClient(replicaSet),
+			want:      "Deployment",
+		},
+		{
+			name:      "Job of a CronJob",
+			ownerKind: "Job",
+			ownerName: job.Name,
+			reader:    newClient(job),
+			want:      "CronJob",
+		},
+		{
+			name:      "ReplicaSet without controller",
+			ownerKind: "ReplicaSet",
+			ownerName: orphan.Name,
+			reader:    newClient(orphan),
+			want:      "ReplicaSet",
+		},
+		{
+			name:      "ReplicaSet not found",
+			ownerKind: "ReplicaSet",
+			ownerName: replicaSet.Name,
+			reader:    newClient(),
+			apiReader: newClient(),
+			want:      "ReplicaSet",
+		},
+		{
+			name:      "ReplicaSet that can not be read",
+			ownerKind: "ReplicaSet",
+			ownerName: replicaSet.Name,
+			reader:    fake.NewClientBuilder().WithScheme(runtime.NewScheme()).Build(),
+			want:      "ReplicaSet",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+			if tt.ownerKind != "" {
+				pod.OwnerReferences = controlledBy(tt.ownerKind, tt.ownerName).OwnerReferences
+			}
+			got, err := PodOwnerKind(context.Background(), tt.reader, tt.apiReader, pod, "default")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PodOwnerKind() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("PodOwnerKind() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
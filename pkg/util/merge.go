@@ -0,0 +1,222 @@
+package util
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/togettoyou/sidecar-go/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// ConflictError is returned by the merge functions when the merge policy is fail.
+type ConflictError struct {
+	Kind string
+	Name string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s %q already exists in the pod and mergePolicy is %s", e.Kind, e.Name, v1alpha1.MergePolicyFail)
+}
+
+// MergeContainers merges the injected containers into the containers of the pod.
+// Containers with the same name are resolved according to policy, which defaults to overwrite.
+func MergeContainers(pods []corev1.Container, injectedContainers []corev1.Container, policy v1alpha1.MergePolicy) ([]corev1.Container, error) {
+	containersInPod := make(map[string]int)
+	for index, container := range pods {
+		containersInPod[container.Name] = index
+	}
+	for i := range injectedContainers {
+		// copy, as the injected containers belong to the cached spec
+		sidecar := injectedContainers[i].DeepCopy()
+		index, ok := containersInPod[sidecar.Name]
+		if !ok {
+			pods = append(pods, *sidecar)
+			containersInPod[sidecar.Name] = len(pods) - 1
+			continue
+		}
+		switch policy {
+		case v1alpha1.MergePolicySkip:
+		case v1alpha1.MergePolicyFail:
+			return nil, &ConflictError{Kind: "container", Name: sidecar.Name}
+		case v1alpha1.MergePolicyMerge:
+			MergeContainer(&pods[index], sidecar)
+		default:
+			pods[index] = *sidecar
+		}
+	}
+	return pods, nil
+}
+
+// ExcludeContainerNames returns the injected containers whose names are not taken by the other containers
+// of the pod, in its field, into which they can not be merged. Containers sharing a name are dropped
+// with policy skip, and denied otherwise, as a pod can not have two containers of the same name.
+func ExcludeContainerNames(injected []corev1.Container, others []corev1.Container, field string, policy v1alpha1.MergePolicy) ([]corev1.Container, error) {
+	names := sets.NewString()
+	for _, container := range others {
+		names.Insert(container.Name)
+	}
+	containers := make([]corev1.Container, 0, len(injected))
+	for _, container := range injected {
+		if !names.Has(container.Name) {
+			containers = append(containers, container)
+			continue
+		}
+		if policy != v1alpha1.MergePolicySkip {
+			return nil, fmt.Errorf("container %q already exists in the %s of the pod", container.Name, field)
+		}
+	}
+	return containers, nil
+}
+
+// overwrites reports whether policy replaces the containers of the pod, which is the default.
+func overwrites(policy v1alpha1.MergePolicy) bool {
+	return policy == "" || policy == v1alpha1.MergePolicyOverwrite
+}
+
+// MergeVolumes merges the additional volumes into the volumes of the pod.
+// Volumes with the same name are resolved according to policy, which defaults to keeping the volume of the pod.
+func MergeVolumes(original []corev1.Volume, additional []corev1.Volume, policy v1alpha1.MergePolicy) ([]corev1.Volume, error) {
+	exists := make(map[string]int)
+	for index, volume := range original {
+		exists[volume.Name] = index
+	}
+
+	for i := range additional {
+		volume := additional[i].DeepCopy()
+		index, ok := exists[volume.Name]
+		if !ok {
+			original = append(original, *volume)
+			exists[volume.Name] = len(original) - 1
+			continue
+		}
+		switch policy {
+		case v1alpha1.MergePolicyOverwrite:
+			original[index] = *volume
+		case v1alpha1.MergePolicyFail:
+			return nil, &ConflictError{Kind: "volume", Name: volume.Name}
+		}
+	}
+
+	return original, nil
+}
+
+// PatchContainers applies the patches to the containers whose names are in appContainers.
+func PatchContainers(containers []corev1.Container, appContainers sets.String, patches []v1alpha1.ContainerPatch) error {
+	for i := range containers {
+		if !appContainers.Has(containers[i].Name) {
+			continue
+		}
+		for _, patch := range patches {
+			selected, err := ContainerSelected(containers[i].Name, patch.Containers)
+			if err != nil {
+				return err
+			}
+			if !selected {
+				continue
+			}
+			MergeContainer(&containers[i], (&corev1.Container{
+				Env:          patch.Env,
+				EnvFrom:      patch.EnvFrom,
+				VolumeMounts: patch.VolumeMounts,
+				Resources:    patch.Resources,
+			}).DeepCopy())
+		}
+	}
+	return nil
+}
+
+// ContainerSelected reports whether the container name matches one of the patterns.
+// An empty pattern list selects all containers.
+func ContainerSelected(name string, patterns []string) (bool, error) {
+	if len(patterns) == 0 {
+		return true, nil
+	}
+	for _, pattern := range patterns {
+		matched, err := path.Match(pattern, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid container pattern %q: %w", pattern, err)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// MergeContainer merges env, envFrom, volumeMounts and resources of patch into container,
+// like a strategic merge patch: entries of patch win over entries with the same key in container.
+func MergeContainer(container *corev1.Container, patch *corev1.Container) {
+	container.Env = MergeEnv(container.Env, patch.Env)
+	container.EnvFrom = MergeEnvFrom(container.EnvFrom, patch.EnvFrom)
+	container.VolumeMounts = MergeVolumeMounts(container.VolumeMounts, patch.VolumeMounts)
+	MergeResources(&container.Resources, &patch.Resources)
+}
+
+// MergeEnv merges env vars by name.
+func MergeEnv(original []corev1.EnvVar, additional []corev1.EnvVar) []corev1.EnvVar {
+	exists := make(map[string]int)
+	for index, env := range original {
+		exists[env.Name] = index
+	}
+	for _, env := range additional {
+		if index, ok := exists[env.Name]; ok {
+			original[index] = env
+			continue
+		}
+		original = append(original, env)
+		exists[env.Name] = len(original) - 1
+	}
+	return original
+}
+
+// MergeEnvFrom appends the env sources not already in original.
+func MergeEnvFrom(original []corev1.EnvFromSource, additional []corev1.EnvFromSource) []corev1.EnvFromSource {
+	for _, source := range additional {
+		found := false
+		for _, exist := range original {
+			if equality.Semantic.DeepEqual(exist, source) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			original = append(original, source)
+		}
+	}
+	return original
+}
+
+// MergeVolumeMounts merges volume mounts by mount path.
+func MergeVolumeMounts(original []corev1.VolumeMount, additional []corev1.VolumeMount) []corev1.VolumeMount {
+	exists := make(map[string]int)
+	for index, mount := range original {
+		exists[mount.MountPath] = index
+	}
+	for _, mount := range additional {
+		if index, ok := exists[mount.MountPath]; ok {
+			original[index] = mount
+			continue
+		}
+		original = append(original, mount)
+		exists[mount.MountPath] = len(original) - 1
+	}
+	return original
+}
+
+// MergeResources merges the limits and requests of additional into original by resource name.
+func MergeResources(original *corev1.ResourceRequirements, additional *corev1.ResourceRequirements) {
+	for name, quantity := range additional.Limits {
+		if original.Limits == nil {
+			original.Limits = make(corev1.ResourceList)
+		}
+		original.Limits[name] = quantity
+	}
+	for name, quantity := range additional.Requests {
+		if original.Requests == nil {
+			original.Requests = make(corev1.ResourceList)
+		}
+		original.Requests[name] = quantity
+	}
+}
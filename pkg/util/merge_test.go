@@ -0,0 +1,343 @@
+package util
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/togettoyou/sidecar-go/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestMergeContainers(t *testing.T) {
+	pod := func() []corev1.Container {
+		return []corev1.Container{
+			{
+				Name:  "app",
+				Image: "app:v1",
+				Env:   []corev1.EnvVar{{Name: "A", Value: "pod"}, {Name: "B", Value: "pod"}},
+			},
+		}
+	}
+	injected := []corev1.Container{
+		{
+			Name:  "app",
+			Image: "app:v2",
+			Env:   []corev1.EnvVar{{Name: "B", Value: "sidecar"}, {Name: "C", Value: "sidecar"}},
+		},
+		{Name: "sidecar", Image: "sidecar:v1"},
+	}
+
+	tests := []struct {
+		name    string
+		policy  v1alpha1.MergePolicy
+		want    []corev1.Container
+		wantErr bool
+	}{
+		{
+			name:   "default overwrites",
+			policy: "",
+			want:   injected,
+		},
+		{
+			name:   "overwrite",
+			policy: v1alpha1.MergePolicyOverwrite,
+			want:   injected,
+		},
+		{
+			name:   "skip keeps the container of the pod",
+			policy: v1alpha1.MergePolicySkip,
+			want:   append(pod(), injected[1]),
+		},
+		{
+			name:    "fail",
+			policy:  v1alpha1.MergePolicyFail,
+			wantErr: true,
+		},
+		{
+			name:   "merge keeps the image and merges env",
+			policy: v1alpha1.MergePolicyMerge,
+			want: []corev1.Container{
+				{
+					Name:  "app",
+					Image: "app:v1",
+					Env: []corev1.EnvVar{
+						{Name: "A", Value: "pod"},
+						{Name: "B", Value: "sidecar"},
+						{Name: "C", Value: "sidecar"},
+					},
+				},
+				injected[1],
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergeContainers(pod(), injected, tt.policy)
+			if tt.wantErr {
+				var conflict *ConflictError
+				if !errors.As(err, &conflict) || conflict.Name != "app" {
+					t.Fatalf("MergeContainers() error = %v, want a conflict on app", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MergeContainers() error = %v", err)
+			}
+			if !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("MergeContainers() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if injected[0].Env[0].Value != "sidecar" || len(injected[0].Env) != 2 {
+		t.Errorf("MergeContainers() modified the injected containers: %+v", injected[0])
+	}
+}
+
+func TestExcludeContainerNames(t *testing.T) {
+	injected := []corev1.Container{{Name: "init"}, {Name: "sidecar"}}
+	others := []corev1.Container{{Name: "sidecar"}}
+
+	tests := []struct {
+		name    string
+		policy  v1alpha1.MergePolicy
+		want    []string
+		wantErr bool
+	}{
+		{name: "skip drops the colliding container", policy: v1alpha1.MergePolicySkip, want: []string{"init"}},
+		{name: "default", policy: "", wantErr: true},
+		{name: "overwrite", policy: v1alpha1.MergePolicyOverwrite, wantErr: true},
+		{name: "merge", policy: v1alpha1.MergePolicyMerge, wantErr: true},
+		{name: "fail", policy: v1alpha1.MergePolicyFail, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExcludeContainerNames(injected, others, "containers", tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExcludeContainerNames() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			names := make([]string, 0, len(got))
+			for _, container := range got {
+				names = append(names, container.Name)
+			}
+			if !equality.Semantic.DeepEqual(names, tt.want) {
+				t.Errorf("ExcludeContainerNames() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeVolumes(t *testing.T) {
+	pod := func() []corev1.Volume {
+		return []corev1.Volume{{Name: "data", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/pod"}}}}
+	}
+	injected := []corev1.Volume{
+		{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		{Name: "logs", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+	}
+
+	tests := []struct {
+		name    string
+		policy  v1alpha1.MergePolicy
+		want    []corev1.Volume
+		wantErr bool
+	}{
+		{name: "default keeps the volume of the pod", policy: "", want: append(pod(), injected[1])},
+		{name: "skip", policy: v1alpha1.MergePolicySkip, want: append(pod(), injected[1])},
+		{name: "merge", policy: v1alpha1.MergePolicyMerge, want: append(pod(), injected[1])},
+		{name: "overwrite", policy: v1alpha1.MergePolicyOverwrite, want: injected},
+		{name: "fail", policy: v1alpha1.MergePolicyFail, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergeVolumes(pod(), injected, tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MergeVolumes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("MergeVolumes() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	tests := []struct {
+		name       string
+		original   []corev1.EnvVar
+		additional []corev1.EnvVar
+		want       []corev1.EnvVar
+	}{
+		{
+			name:       "append to empty",
+			additional: []corev1.EnvVar{{Name: "A", Value: "1"}},
+			want:       []corev1.EnvVar{{Name: "A", Value: "1"}},
+		},
+		{
+			name:       "replace in place and append",
+			original:   []corev1.EnvVar{{Name: "A", Value: "1"}, {Name: "B", Value: "2"}},
+			additional: []corev1.EnvVar{{Name: "A", Value: "3"}, {Name: "C", Value: "4"}},
+			want:       []corev1.EnvVar{{Name: "A", Value: "3"}, {Name: "B", Value: "2"}, {Name: "C", Value: "4"}},
+		},
+		{
+			name:       "last duplicate wins",
+			additional: []corev1.EnvVar{{Name: "A", Value: "1"}, {Name: "A", Value: "2"}},
+			want:       []corev1.EnvVar{{Name: "A", Value: "2"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergeEnv(tt.original, tt.additional); !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("MergeEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeVolumeMounts(t *testing.T) {
+	tests := []struct {
+		name       string
+		original   []corev1.VolumeMount
+		additional []corev1.VolumeMount
+		want       []corev1.VolumeMount
+	}{
+		{
+			name:       "merged by mount path",
+			original:   []corev1.VolumeMount{{Name: "a", MountPath: "/a"}, {Name: "b", MountPath: "/b"}},
+			additional: []corev1.VolumeMount{{Name: "c", MountPath: "/a", ReadOnly: true}, {Name: "b", MountPath: "/c"}},
+			want: []corev1.VolumeMount{
+				{Name: "c", MountPath: "/a", ReadOnly: true},
+				{Name: "b", MountPath: "/b"},
+				{Name: "b", MountPath: "/c"},
+			},
+		},
+		{
+			name:     "nothing to merge",
+			original: []corev1.VolumeMount{{Name: "a", MountPath: "/a"}},
+			want:     []corev1.VolumeMount{{Name: "a", MountPath: "/a"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergeVolumeMounts(tt.original, tt.additional); !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("MergeVolumeMounts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeResources(t *testing.T) {
+	tests := []struct {
+		name       string
+		original   corev1.ResourceRequirements
+		additional corev1.ResourceRequirements
+		want       corev1.ResourceRequirements
+	}{
+		{
+			name: "into empty requirements",
+			additional: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+			},
+			want: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+			},
+		},
+		{
+			name: "merged by resource name",
+			original: corev1.ResourceRequirements{
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+			},
+			additional: corev1.ResourceRequirements{
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+				Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+			},
+			want: corev1.ResourceRequirements{
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("128Mi")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.original
+			MergeResources(&got, &tt.additional)
+			if !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("MergeResources() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchContainers(t *testing.T) {
+	containers := func() []corev1.Container {
+		return []corev1.Container{
+			{Name: "web", Env: []corev1.EnvVar{{Name: "A", Value: "pod"}}},
+			{Name: "worker"},
+			{Name: "proxy"},
+		}
+	}
+	appContainers := sets.NewString("web", "worker")
+
+	tests := []struct {
+		name    string
+		patches []v1alpha1.ContainerPatch
+		// want are the env vars of the containers by name.
+		want    map[string][]corev1.EnvVar
+		wantErr bool
+	}{
+		{
+			name:    "all app containers",
+			patches: []v1alpha1.ContainerPatch{{Env: []corev1.EnvVar{{Name: "A", Value: "patch"}}}},
+			want: map[string][]corev1.EnvVar{
+				"web":    {{Name: "A", Value: "patch"}},
+				"worker": {{Name: "A", Value: "patch"}},
+			},
+		},
+		{
+			name: "selected by pattern, later patches win",
+			patches: []v1alpha1.ContainerPatch{
+				{Containers: []string{"w*"}, Env: []corev1.EnvVar{{Name: "B", Value: "first"}}},
+				{Containers: []string{"worker"}, Env: []corev1.EnvVar{{Name: "B", Value: "second"}}},
+			},
+			want: map[string][]corev1.EnvVar{
+				"web":    {{Name: "A", Value: "pod"}, {Name: "B", Value: "first"}},
+				"worker": {{Name: "B", Value: "second"}},
+			},
+		},
+		{
+			name:    "injected containers are not patched",
+			patches: []v1alpha1.ContainerPatch{{Containers: []string{"proxy"}, Env: []corev1.EnvVar{{Name: "A", Value: "patch"}}}},
+			want: map[string][]corev1.EnvVar{
+				"web": {{Name: "A", Value: "pod"}},
+			},
+		},
+		{
+			name:    "invalid pattern",
+			patches: []v1alpha1.ContainerPatch{{Containers: []string{"[w"}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := containers()
+			err := PatchContainers(got, appContainers, tt.patches)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PatchContainers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			for _, container := range got {
+				if !equality.Semantic.DeepEqual(container.Env, tt.want[container.Name]) {
+					t.Errorf("PatchContainers() env of %s = %v, want %v", container.Name, container.Env, tt.want[container.Name])
+				}
+			}
+		})
+	}
+}
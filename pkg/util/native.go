@@ -0,0 +1,76 @@
+package util
+
+import (
+	"encoding/json"
+
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+)
+
+// ContainerRestartPolicyAlways is the restartPolicy of native sidecar init containers.
+const ContainerRestartPolicyAlways = "Always"
+
+// nativeSidecarVersion is the first Kubernetes version enabling native sidecars by default.
+var nativeSidecarVersion = version.MustParseGeneric("1.29.0")
+
+// NativeSidecarSupported reports whether the API server supports native sidecar containers.
+func NativeSidecarSupported(config *rest.Config) (bool, error) {
+	client, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return false, err
+	}
+	info, err := client.ServerVersion()
+	if err != nil {
+		return false, err
+	}
+	serverVersion, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return false, err
+	}
+	return serverVersion.AtLeast(nativeSidecarVersion), nil
+}
+
+// InitContainerRestartPolicies returns the restartPolicy of the init containers of a raw pod, by container name.
+// The vendored corev1.Container predates the field, so it is read from the JSON.
+func InitContainerRestartPolicies(rawPod []byte) (map[string]string, error) {
+	var pod struct {
+		Spec struct {
+			InitContainers []struct {
+				Name          string `json:"name"`
+				RestartPolicy string `json:"restartPolicy"`
+			} `json:"initContainers"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(rawPod, &pod); err != nil {
+		return nil, err
+	}
+	policies := make(map[string]string)
+	for _, container := range pod.Spec.InitContainers {
+		if container.RestartPolicy != "" {
+			policies[container.Name] = container.RestartPolicy
+		}
+	}
+	return policies, nil
+}
+
+// SetInitContainerRestartPolicies sets the restartPolicy of the init containers of a raw pod, by container name.
+func SetInitContainerRestartPolicies(rawPod []byte, policies map[string]string) ([]byte, error) {
+	var pod map[string]interface{}
+	if err := json.Unmarshal(rawPod, &pod); err != nil {
+		return nil, err
+	}
+	spec, _ := pod["spec"].(map[string]interface{})
+	initContainers, _ := spec["initContainers"].([]interface{})
+	for _, item := range initContainers {
+		container, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := container["name"].(string)
+		if policy, ok := policies[name]; ok {
+			container["restartPolicy"] = policy
+		}
+	}
+	return json.Marshal(pod)
+}
@@ -0,0 +1,75 @@
+package util
+
+import (
+	"encoding/json"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+func TestInitContainerRestartPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		rawPod  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "no init containers",
+			rawPod: `{"spec":{"containers":[{"name":"web"}]}}`,
+			want:   map[string]string{},
+		},
+		{
+			name:   "only set policies",
+			rawPod: `{"spec":{"initContainers":[{"name":"setup"},{"name":"proxy","restartPolicy":"Always"}]}}`,
+			want:   map[string]string{"proxy": "Always"},
+		},
+		{
+			name:    "invalid pod",
+			rawPod:  `{"spec":`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := InitContainerRestartPolicies([]byte(tt.rawPod))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InitContainerRestartPolicies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("InitContainerRestartPolicies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetInitContainerRestartPolicies(t *testing.T) {
+	rawPod := `{"metadata":{"name":"web"},"spec":{"initContainers":[{"name":"setup","image":"setup"},{"name":"proxy","image":"proxy"}],"containers":[{"name":"web"}]}}`
+
+	got, err := SetInitContainerRestartPolicies([]byte(rawPod), map[string]string{"proxy": ContainerRestartPolicyAlways, "web": ContainerRestartPolicyAlways})
+	if err != nil {
+		t.Fatalf("SetInitContainerRestartPolicies() error = %v", err)
+	}
+	policies, err := InitContainerRestartPolicies(got)
+	if err != nil {
+		t.Fatalf("InitContainerRestartPolicies() error = %v", err)
+	}
+	if want := map[string]string{"proxy": "Always"}; !equality.Semantic.DeepEqual(policies, want) {
+		t.Errorf("SetInitContainerRestartPolicies() policies = %v, want %v", policies, want)
+	}
+
+	// the rest of the pod is kept, and the app containers get no restartPolicy
+	var pod struct {
+		Metadata map[string]interface{}   `json:"metadata"`
+		Spec     map[string][]interface{} `json:"spec"`
+	}
+	if err := json.Unmarshal(got, &pod); err != nil {
+		t.Fatal(err)
+	}
+	if pod.Metadata["name"] != "web" || pod.Spec["initContainers"][1].(map[string]interface{})["image"] != "proxy" {
+		t.Errorf("SetInitContainerRestartPolicies() = %s, want the pod kept", got)
+	}
+	if _, ok := pod.Spec["containers"][0].(map[string]interface{})["restartPolicy"]; ok {
+		t.Errorf("SetInitContainerRestartPolicies() = %s, want no restartPolicy on the containers", got)
+	}
+}
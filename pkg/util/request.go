@@ -0,0 +1,93 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodRequests returns the SidecarGo requested by the pod in AnnotationInject,
+// by "name" or "namespace/name". "true" and "false" request none.
+func PodRequests(pod *corev1.Pod) []string {
+	value := strings.TrimSpace(pod.Annotations[AnnotationInject])
+	if value == "" || strings.EqualFold(value, "true") || strings.EqualFold(value, "false") {
+		return nil
+	}
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// resolveRequest returns the key of the SidecarGo requested as item by a pod of the namespace.
+// A name is resolved to the requestable SidecarGo of the namespace first, then to the ClusterSidecarGo,
+// then to any SidecarGo of the namespace.
+// The caller holds the lock of the store.
+func (s *SpecStore) resolveRequest(item, namespace string) string {
+	if strings.Contains(item, "/") {
+		return item
+	}
+	namespacedName := namespace + "/" + item
+	if spec, ok := s.specs[namespacedName]; ok && spec.Requestable {
+		return namespacedName
+	}
+	if _, ok := s.specs[item]; ok || s.otherClasses.Has(item) {
+		return item
+	}
+	if _, ok := s.specs[namespacedName]; ok || s.otherClasses.Has(namespacedName) {
+		return namespacedName
+	}
+	return item
+}
+
+// podRequested reports whether the pod requests the SidecarGo with the given key.
+// The caller holds the lock of the store.
+func (s *SpecStore) podRequested(namespacedName string, pod *corev1.Pod, namespace *corev1.Namespace) bool {
+	for _, item := range PodRequests(pod) {
+		if s.resolveRequest(item, namespace.Name) == namespacedName {
+			return true
+		}
+	}
+	return false
+}
+
+// PodRequestErrors returns why the SidecarGo requested by the pod can not be injected:
+// they do not exist, are not requestable, or do not inject the namespace or kind of pod.
+// Requests for SidecarGo of other classes are left to their installation.
+func (s *SpecStore) PodRequestErrors(ctx context.Context, pod *corev1.Pod, namespace *corev1.Namespace) []*InjectError {
+	if len(PodRequests(pod)) == 0 {
+		return nil
+	}
+	ownerKind := s.podOwnerKind(ctx, pod, namespace)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	errs := make([]*InjectError, 0)
+	for _, item := range PodRequests(pod) {
+		namespacedName := s.resolveRequest(item, namespace.Name)
+		spec, ok := s.specs[namespacedName]
+		var err error
+		switch {
+		case !ok && s.otherClasses.Has(namespacedName):
+			continue
+		case !ok:
+			err = fmt.Errorf("requested in annotation %s but not found", AnnotationInject)
+		case !spec.Requestable:
+			err = fmt.Errorf("requested in annotation %s but not requestable", AnnotationInject)
+		default:
+			reason := s.podMismatchReason(namespacedName, spec, pod, namespace, ownerKind)
+			if reason == "" {
+				continue
+			}
+			err = fmt.Errorf("requested in annotation %s but can not be injected in namespace %s: %s",
+				AnnotationInject, namespace.Name, reason)
+		}
+		errs = append(errs, &InjectError{NamespacedName: namespacedName, Err: err})
+	}
+	return errs
+}
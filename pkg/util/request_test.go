@@ -0,0 +1,152 @@
+package util
+
+import (
+	"context"
+	"testing"
+
+	"github.com/togettoyou/sidecar-go/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodRequests(t *testing.T) {
+	tests := []struct {
+		name  string
+		value *string
+		want  []string
+	}{
+		{name: "no annotation"},
+		{name: "false disables injection", value: stringPtr("false")},
+		{name: "true requests nothing", value: stringPtr("True")},
+		{name: "empty", value: stringPtr(" ")},
+		{name: "name", value: stringPtr("proxy"), want: []string{"proxy"}},
+		{name: "list", value: stringPtr(" proxy, default/logs,,"), want: []string{"proxy", "default/logs"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{}
+			if tt.value != nil {
+				pod.Annotations = map[string]string{AnnotationInject: *tt.value}
+			}
+			if got := PodRequests(pod); !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("PodRequests() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		// specs are the keys of the loaded SidecarGo, requestable if true.
+		specs        map[string]bool
+		otherClasses []string
+		item         string
+		want         string
+	}{
+		{
+			name:  "requestable SidecarGo of the namespace first",
+			specs: map[string]bool{"default/proxy": true, "proxy": true},
+			item:  "proxy",
+			want:  "default/proxy",
+		},
+		{
+			name:  "then the ClusterSidecarGo",
+			specs: map[string]bool{"default/proxy": false, "proxy": true},
+			item:  "proxy",
+			want:  "proxy",
+		},
+		{
+			name:         "then the ClusterSidecarGo of another class",
+			specs:        map[string]bool{"default/proxy": false},
+			otherClasses: []string{"proxy"},
+			item:         "proxy",
+			want:         "proxy",
+		},
+		{
+			name:  "then any SidecarGo of the namespace",
+			specs: map[string]bool{"default/proxy": false, "other/proxy": true},
+			item:  "proxy",
+			want:  "default/proxy",
+		},
+		{
+			name:  "namespaced name",
+			specs: map[string]bool{"default/proxy": true},
+			item:  "other/proxy",
+			want:  "other/proxy",
+		},
+		{
+			name: "unknown name",
+			item: "proxy",
+			want: "proxy",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSpecStore(nil, nil, "")
+			for key, requestable := range tt.specs {
+				if err := s.Update(key, &v1alpha1.SidecarGoSpec{Requestable: requestable}); err != nil {
+					t.Fatalf("Update() error = %v", err)
+				}
+			}
+			for _, key := range tt.otherClasses {
+				s.setOtherClass(key, true)
+			}
+			if got := s.resolveRequest(tt.item, "default"); got != tt.want {
+				t.Errorf("resolveRequest() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodRequestErrors(t *testing.T) {
+	s := NewSpecStore(nil, nil, "")
+	for key, spec := range map[string]*v1alpha1.SidecarGoSpec{
+		"default/proxy": {Requestable: true},
+		"default/logs":  {},
+		"mesh":          {Requestable: true, Namespace: "other"},
+	} {
+		if err := s.Update(key, spec); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+	}
+	s.setOtherClass("canary", true)
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+
+	tests := []struct {
+		name  string
+		value string
+		// want are the keys of the SidecarGo in error.
+		want []string
+	}{
+		{name: "requestable", value: "proxy"},
+		{name: "disabled", value: "false"},
+		{name: "requests nothing", value: "true"},
+		{name: "unknown", value: "missing", want: []string{"missing"}},
+		{name: "not requestable", value: "logs", want: []string{"default/logs"}},
+		{name: "not injecting the namespace", value: "mesh", want: []string{"mesh"}},
+		{name: "another class", value: "canary"},
+		{name: "list", value: "proxy,logs,missing", want: []string{"default/logs", "missing"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:        "web",
+				Namespace:   "default",
+				Annotations: map[string]string{AnnotationInject: tt.value},
+			}}
+			got := make([]string, 0)
+			for _, err := range s.PodRequestErrors(context.Background(), pod, namespace) {
+				got = append(got, err.NamespacedName)
+			}
+			if !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("PodRequestErrors() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
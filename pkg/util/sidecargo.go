@@ -1,100 +1,175 @@
 package util
 
 import (
-	"sync"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
 
 	"github.com/togettoyou/sidecar-go/api/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/rand"
 )
 
-var (
-	sidecarGoSpecM     = make(map[string]*v1alpha1.SidecarGoSpec, 0)
-	sidecarGoSelectorM = make(map[string]labels.Selector, 0)
-	sidecarGoSpecMu    sync.RWMutex
-)
-
-func UpdateSidecarGoSpec(namespacedName string, spec *v1alpha1.SidecarGoSpec) error {
-	sidecarGoSpecMu.Lock()
-	defer sidecarGoSpecMu.Unlock()
+// MatchedSidecarGo is a loaded SidecarGo matching a pod.
+type MatchedSidecarGo struct {
+	NamespacedName string
+	Spec           *v1alpha1.SidecarGoSpec
+	Revision       string
+}
 
-	if namespacedName == "" {
-		return nil
-	}
-	if spec == nil {
-		delete(sidecarGoSpecM, namespacedName)
-		delete(sidecarGoSelectorM, namespacedName)
-		return nil
-	}
-	sidecarGoSpecM[namespacedName] = spec
-	if spec.Selector != nil {
-		selector, err := v1.LabelSelectorAsSelector(spec.Selector)
-		if err != nil {
-			return err
-		}
-		sidecarGoSelectorM[namespacedName] = selector
+// SpecRevision returns a hash of the parts of the spec injected into pods.
+func SpecRevision(spec *v1alpha1.SidecarGoSpec) (string, error) {
+	data, err := json.Marshal(struct {
+		InitContainers   []corev1.Container        `json:"initContainers,omitempty"`
+		Containers       []corev1.Container        `json:"containers,omitempty"`
+		Volumes          []corev1.Volume           `json:"volumes,omitempty"`
+		ContainerPatches []v1alpha1.ContainerPatch `json:"containerPatches,omitempty"`
+		SidecarMode      v1alpha1.SidecarMode      `json:"sidecarMode,omitempty"`
+		Templated        bool                      `json:"templated,omitempty"`
+	}{spec.InitContainers, spec.Containers, spec.Volumes, spec.ContainerPatches, spec.SidecarMode, spec.Templated})
+	if err != nil {
+		return "", err
 	}
-	return nil
+	hasher := fnv.New32a()
+	_, _ = hasher.Write(data)
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32())), nil
 }
 
-func PodMatchedSidecarGo(pod *corev1.Pod) ([]*v1alpha1.SidecarGoSpec, bool) {
-	sidecarGoSpecMu.RLock()
-	defer sidecarGoSpecMu.RUnlock()
+// sortMatched orders matched by descending priority, then by namespaced name,
+// so that every webhook replica injects in the same order.
+func sortMatched(matched []MatchedSidecarGo) {
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].Spec.Priority != matched[j].Spec.Priority {
+			return matched[i].Spec.Priority > matched[j].Spec.Priority
+		}
+		return matched[i].NamespacedName < matched[j].NamespacedName
+	})
+}
 
-	specs := make([]*v1alpha1.SidecarGoSpec, 0)
+// SkipReason returns why the pod opted out of the SidecarGo, or "" if it did not.
+func SkipReason(namespacedName string, pod *corev1.Pod) string {
+	if strings.EqualFold(pod.Annotations[AnnotationInject], "false") {
+		return SkipReasonInjectDisabled
+	}
+	_, name, _ := strings.Cut(namespacedName, "/")
+	for _, item := range strings.Split(pod.Annotations[AnnotationSkip], ",") {
+		item = strings.TrimSpace(item)
+		if item != "" && (item == namespacedName || item == name) {
+			return SkipReasonSkipListed
+		}
+	}
+	return ""
+}
 
-	for namespacedName, spec := range sidecarGoSpecM {
-		if spec.Namespace != "" && spec.Namespace != pod.Namespace {
+// FilterSkipped removes the SidecarGo the pod opted out of from matched,
+// and records the skipped ones with their reason on the pod, along with the ones
+// recorded by the installations of other classes.
+func FilterSkipped(pod *corev1.Pod, matched []MatchedSidecarGo) []MatchedSidecarGo {
+	injected := make([]MatchedSidecarGo, 0, len(matched))
+	skipped := ParseRevisions(pod.Annotations[AnnotationSkipped])
+	for _, m := range matched {
+		if reason := SkipReason(m.NamespacedName, pod); reason != "" {
+			skipped[m.NamespacedName] = reason
 			continue
 		}
-		if selector, ok := sidecarGoSelectorM[namespacedName]; ok {
-			if !selector.Empty() && selector.Matches(labels.Set(pod.Labels)) {
-				specs = append(specs, spec)
-			}
-		} else {
-			if spec.Namespace != "" && spec.Namespace == pod.Namespace {
-				specs = append(specs, spec)
-			}
-		}
+		injected = append(injected, m)
 	}
-	ok := false
-	if len(specs) > 0 {
-		ok = true
+	if len(skipped) > 0 {
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string)
+		}
+		pod.Annotations[AnnotationSkipped] = FormatRevisions(skipped)
 	}
+	return injected
+}
 
-	return specs, ok
+// FilterInjected removes the SidecarGo already injected into the pod from matched,
+// so that updating an injected pod does not inject it again.
+func FilterInjected(pod *corev1.Pod, matched []MatchedSidecarGo) []MatchedSidecarGo {
+	value, ok := pod.Annotations[AnnotationRevisions]
+	if !ok {
+		return matched
+	}
+	revisions := ParseRevisions(value)
+	filtered := make([]MatchedSidecarGo, 0, len(matched))
+	for _, m := range matched {
+		if _, ok := revisions[m.NamespacedName]; !ok {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
 }
 
-func MergeContainers(pods []corev1.Container, injectedContainers []corev1.Container) []corev1.Container {
-	containersInPod := make(map[string]int)
-	for index, container := range pods {
-		containersInPod[container.Name] = index
-	}
-	for _, sidecar := range injectedContainers {
-		if index, ok := containersInPod[sidecar.Name]; ok {
-			pods[index] = sidecar
-			continue
+// PodInjectedState reports whether the pod was injected by the SidecarGo,
+// and whether it was injected with the given revision of the spec.
+// Pods injected before revisions were recorded are checked by container names and images.
+func PodInjectedState(namespacedName, revision string, spec *v1alpha1.SidecarGoSpec, pod *corev1.Pod) (injected, upToDate bool) {
+	if value, ok := pod.Annotations[AnnotationRevisions]; ok {
+		injectedRevision, ok := ParseRevisions(value)[namespacedName]
+		return ok, ok && injectedRevision == revision
+	}
+
+	images := make(map[string]string)
+	for _, container := range pod.Spec.InitContainers {
+		images[container.Name] = container.Image
+	}
+	for _, container := range pod.Spec.Containers {
+		images[container.Name] = container.Image
+	}
+
+	injected, upToDate = true, true
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for _, container := range containers {
+			image, ok := images[container.Name]
+			if !ok {
+				return false, false
+			}
+			if image != container.Image {
+				upToDate = false
+			}
 		}
-		pods = append(pods, sidecar)
 	}
-	return pods
+	return injected, upToDate
 }
 
-func MergeVolumes(original []corev1.Volume, additional []corev1.Volume) []corev1.Volume {
-	exists := sets.NewString()
-	for _, volume := range original {
-		exists.Insert(volume.Name)
+// SetInjectedAnnotations records the injected SidecarGo objects and their revisions on the pod.
+func SetInjectedAnnotations(pod *corev1.Pod, matched []MatchedSidecarGo) {
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	revisions := ParseRevisions(pod.Annotations[AnnotationRevisions])
+	for _, m := range matched {
+		revisions[m.NamespacedName] = m.Revision
+	}
+	names := make([]string, 0, len(revisions))
+	for namespacedName := range revisions {
+		names = append(names, namespacedName)
 	}
+	sort.Strings(names)
+	pod.Annotations[AnnotationInjected] = strings.Join(names, ",")
+	pod.Annotations[AnnotationRevisions] = FormatRevisions(revisions)
+}
 
-	for _, volume := range additional {
-		if exists.Has(volume.Name) {
-			continue
+// ParseRevisions parses a "namespace/name=revision,..." annotation value.
+func ParseRevisions(value string) map[string]string {
+	revisions := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		namespacedName, revision, ok := strings.Cut(item, "=")
+		if ok && namespacedName != "" {
+			revisions[namespacedName] = revision
 		}
-		original = append(original, volume)
-		exists.Insert(volume.Name)
 	}
+	return revisions
+}
 
-	return original
+// FormatRevisions formats revisions as a "namespace/name=revision,..." annotation value.
+func FormatRevisions(revisions map[string]string) string {
+	items := make([]string, 0, len(revisions))
+	for namespacedName, revision := range revisions {
+		items = append(items, namespacedName+"="+revision)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
 }
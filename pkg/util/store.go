@@ -0,0 +1,367 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/togettoyou/sidecar-go/api/v1alpha1"
+	"github.com/togettoyou/sidecar-go/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var storelog = logf.Log.WithName("spec-store")
+
+// SpecStore holds the SidecarGo and ClusterSidecarGo loaded for injection, by key: namespace/name
+// for a SidecarGo, which only injects the pods of its namespace, and name for a ClusterSidecarGo.
+// Once started, it follows their informers of the manager cache on every replica,
+// so that the webhooks of the replicas not holding the leader lease inject as well.
+type SpecStore struct {
+	// Cache is the informer cache the store is populated from.
+	Cache cache.Cache
+	// APIReader reads the owners of pods missing from Cache.
+	APIReader client.Reader
+	// Class is the injector class of this installation, SidecarGo of other classes are not loaded.
+	Class string
+	// ExcludedNamespaces are never sent to the pod webhook, so their pods match no SidecarGo
+	// and are neither counted nor restarted by the controller.
+	ExcludedNamespaces sets.String
+
+	mu                  sync.RWMutex
+	specs               map[string]*v1alpha1.SidecarGoSpec
+	selectors           map[string]labels.Selector
+	namespaceSelectors  map[string]labels.Selector
+	annotationSelectors map[string]labels.Selector
+	nodeSelectors       map[string]labels.Selector
+	excludeSelectors    map[string]labels.Selector
+	revisions           map[string]string
+	// otherClasses are the keys of the SidecarGo of other classes, whose requests are left to their installation.
+	otherClasses sets.String
+	synced       bool
+}
+
+// NewSpecStore returns an empty store populated from c when started.
+func NewSpecStore(c cache.Cache, apiReader client.Reader, class string) *SpecStore {
+	return &SpecStore{
+		Cache:               c,
+		APIReader:           apiReader,
+		Class:               class,
+		specs:               make(map[string]*v1alpha1.SidecarGoSpec),
+		selectors:           make(map[string]labels.Selector),
+		namespaceSelectors:  make(map[string]labels.Selector),
+		annotationSelectors: make(map[string]labels.Selector),
+		nodeSelectors:       make(map[string]labels.Selector),
+		excludeSelectors:    make(map[string]labels.Selector),
+		revisions:           make(map[string]string),
+		otherClasses:        sets.NewString(),
+	}
+}
+
+// Start loads the SidecarGo and ClusterSidecarGo of the cache once it synced, and keeps following their changes.
+func (s *SpecStore) Start(ctx context.Context) error {
+	for _, obj := range []client.Object{&v1alpha1.SidecarGo{}, &v1alpha1.ClusterSidecarGo{}} {
+		informer, err := s.Cache.GetInformer(ctx, obj)
+		if err != nil {
+			return err
+		}
+		informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+			AddFunc: s.load,
+			UpdateFunc: func(_, obj interface{}) {
+				s.load(obj)
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if sidecarGo, ok := obj.(v1alpha1.SidecarGoObject); ok {
+					s.setOtherClass(v1alpha1.KeyOf(sidecarGo), false)
+					_ = s.Update(v1alpha1.KeyOf(sidecarGo), nil)
+				}
+			},
+		})
+	}
+	if !s.Cache.WaitForCacheSync(ctx) {
+		return errors.New("unable to sync the SidecarGo cache")
+	}
+
+	// the informers may not have notified the handler of every cached SidecarGo yet
+	sidecarGos, err := v1alpha1.ListSidecarGoObjects(ctx, s.Cache)
+	if err != nil {
+		return err
+	}
+	for _, sidecarGo := range sidecarGos {
+		s.load(sidecarGo)
+	}
+	s.mu.Lock()
+	s.synced = true
+	s.mu.Unlock()
+	storelog.Info("loaded SidecarGo", "count", len(s.Names()))
+	return nil
+}
+
+// NeedLeaderElection is false, as the webhook of every replica injects.
+func (s *SpecStore) NeedLeaderElection() bool {
+	return false
+}
+
+// ReadyCheck fails until the store loaded the SidecarGo of the cache,
+// so that the webhook does not receive pods it would let through uninjected.
+func (s *SpecStore) ReadyCheck(_ *http.Request) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.synced {
+		return errors.New("SidecarGo not loaded yet")
+	}
+	return nil
+}
+
+// load loads a SidecarGo from the informer, or unloads it if it belongs to another class.
+func (s *SpecStore) load(obj interface{}) {
+	sidecarGo, ok := obj.(v1alpha1.SidecarGoObject)
+	if !ok {
+		return
+	}
+	namespacedName := v1alpha1.KeyOf(sidecarGo)
+	spec := sidecarGo.GetSpec()
+	s.setOtherClass(namespacedName, spec.Class != s.Class)
+	if spec.Class != s.Class {
+		spec = nil
+	}
+	if err := s.Update(namespacedName, spec); err != nil {
+		storelog.Info("ignoring invalid SidecarGo", "sidecargo", namespacedName, "reason", err.Error())
+	}
+}
+
+func (s *SpecStore) setOtherClass(namespacedName string, other bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if other {
+		s.otherClasses.Insert(namespacedName)
+	} else {
+		s.otherClasses.Delete(namespacedName)
+	}
+}
+
+// Update loads a copy of spec under the key, or unloads it if spec is nil.
+// An invalid spec is unloaded and its error returned.
+func (s *SpecStore) Update(namespacedName string, spec *v1alpha1.SidecarGoSpec) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer func() {
+		metrics.SpecsLoaded.Set(float64(len(s.specs)))
+	}()
+
+	if namespacedName == "" {
+		return nil
+	}
+	delete(s.specs, namespacedName)
+	delete(s.selectors, namespacedName)
+	delete(s.namespaceSelectors, namespacedName)
+	delete(s.annotationSelectors, namespacedName)
+	delete(s.nodeSelectors, namespacedName)
+	delete(s.excludeSelectors, namespacedName)
+	delete(s.revisions, namespacedName)
+	if spec == nil {
+		return nil
+	}
+	parsed, err := parseSpec(spec)
+	if err != nil {
+		return err
+	}
+	// specs from the informer are shared with the cache
+	s.specs[namespacedName] = spec.DeepCopy()
+	if parsed.selector != nil {
+		s.selectors[namespacedName] = parsed.selector
+	}
+	if parsed.namespaceSelector != nil {
+		s.namespaceSelectors[namespacedName] = parsed.namespaceSelector
+	}
+	if parsed.annotationSelector != nil {
+		s.annotationSelectors[namespacedName] = parsed.annotationSelector
+	}
+	if parsed.nodeSelector != nil {
+		s.nodeSelectors[namespacedName] = parsed.nodeSelector
+	}
+	if parsed.excludeSelector != nil && !parsed.excludeSelector.Empty() {
+		s.excludeSelectors[namespacedName] = parsed.excludeSelector
+	}
+	s.revisions[namespacedName] = parsed.revision
+	return nil
+}
+
+// ValidateSpec returns the error Update would return for spec, without loading it.
+func ValidateSpec(spec *v1alpha1.SidecarGoSpec) error {
+	_, err := parseSpec(spec)
+	return err
+}
+
+// parsedSpec holds the selectors and revision of a SidecarGo spec.
+type parsedSpec struct {
+	selector, namespaceSelector, annotationSelector, nodeSelector, excludeSelector labels.Selector
+	revision                                                                       string
+}
+
+// parseSpec parses the selectors of spec, validates its templates and computes its revision.
+func parseSpec(spec *v1alpha1.SidecarGoSpec) (*parsedSpec, error) {
+	parsed := &parsedSpec{}
+	var err error
+	if spec.Selector != nil {
+		if parsed.selector, err = v1.LabelSelectorAsSelector(spec.Selector); err != nil {
+			return nil, err
+		}
+	}
+	if spec.NamespaceSelector != nil {
+		if parsed.namespaceSelector, err = v1.LabelSelectorAsSelector(spec.NamespaceSelector); err != nil {
+			return nil, err
+		}
+	}
+	if spec.AnnotationSelector != nil {
+		if parsed.annotationSelector, err = v1.LabelSelectorAsSelector(spec.AnnotationSelector); err != nil {
+			return nil, err
+		}
+	}
+	if spec.NodeSelector != nil {
+		if parsed.nodeSelector, err = v1.LabelSelectorAsSelector(spec.NodeSelector); err != nil {
+			return nil, err
+		}
+	}
+	if spec.ExcludeSelector != nil {
+		if parsed.excludeSelector, err = v1.LabelSelectorAsSelector(spec.ExcludeSelector); err != nil {
+			return nil, err
+		}
+	}
+	if err := ValidateTemplates(spec); err != nil {
+		return nil, err
+	}
+	if parsed.revision, err = SpecRevision(spec); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// Names returns the namespaced names of all loaded SidecarGo.
+func (s *SpecStore) Names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.specs))
+	for namespacedName := range s.specs {
+		names = append(names, namespacedName)
+	}
+	return names
+}
+
+// PodMatchReasons returns the loaded SidecarGo matching the pod in the given namespace,
+// and why each of the other loaded SidecarGo does not match it.
+func (s *SpecStore) PodMatchReasons(ctx context.Context, pod *corev1.Pod, namespace *corev1.Namespace) ([]MatchedSidecarGo, map[string]string) {
+	ownerKind := s.podOwnerKind(ctx, pod, namespace)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matched := make([]MatchedSidecarGo, 0)
+	mismatched := make(map[string]string)
+
+	for namespacedName, spec := range s.specs {
+		if reason := s.podMismatchReason(namespacedName, spec, pod, namespace, ownerKind); reason != "" {
+			mismatched[namespacedName] = reason
+			continue
+		}
+		matched = append(matched, MatchedSidecarGo{
+			NamespacedName: namespacedName,
+			Spec:           spec,
+			Revision:       s.revisions[namespacedName],
+		})
+	}
+	sortMatched(matched)
+
+	return matched, mismatched
+}
+
+// PodMatchedNames returns the namespaced names of all SidecarGo matching the pod.
+func (s *SpecStore) PodMatchedNames(ctx context.Context, pod *corev1.Pod, namespace *corev1.Namespace) []string {
+	ownerKind := s.podOwnerKind(ctx, pod, namespace)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0)
+	for namespacedName, spec := range s.specs {
+		if s.podMismatchReason(namespacedName, spec, pod, namespace, ownerKind) == "" {
+			names = append(names, namespacedName)
+		}
+	}
+	return names
+}
+
+// PodMatches reports whether the pod matches the loaded SidecarGo with the given namespaced name.
+func (s *SpecStore) PodMatches(ctx context.Context, namespacedName string, pod *corev1.Pod, namespace *corev1.Namespace) bool {
+	ownerKind := s.podOwnerKind(ctx, pod, namespace)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	spec, ok := s.specs[namespacedName]
+	if !ok {
+		return false
+	}
+	return s.podMismatchReason(namespacedName, spec, pod, namespace, ownerKind) == ""
+}
+
+// podMismatchReason returns why the pod does not match the spec or is excluded from it, or "" if it matches.
+// ownerKind is the kind of the workload controlling the pod.
+func (s *SpecStore) podMismatchReason(namespacedName string, spec *v1alpha1.SidecarGoSpec, pod *corev1.Pod, namespace *corev1.Namespace, ownerKind string) string {
+	if reason := s.podSelectionMismatchReason(namespacedName, spec, pod, namespace, ownerKind); reason != "" {
+		return reason
+	}
+	if sets.NewString(spec.ExcludeNamespaces...).Has(namespace.Name) {
+		return MismatchReasonExcludeNamespace
+	}
+	if selector, ok := s.excludeSelectors[namespacedName]; ok && selector.Matches(labels.Set(pod.Labels)) {
+		return MismatchReasonExcludeSelector
+	}
+	return ""
+}
+
+// podSelectionMismatchReason returns why the pod does not match the namespace, pod matchers and selectors of the spec,
+// or "" if it matches. A spec without pod selector matches all pods of the namespaces and pod matchers
+// it is restricted to. Pods requesting a requestable spec match it regardless of the pod selector.
+// ownerKind is the kind of the workload controlling the pod.
+func (s *SpecStore) podSelectionMismatchReason(namespacedName string, spec *v1alpha1.SidecarGoSpec, pod *corev1.Pod, namespace *corev1.Namespace, ownerKind string) string {
+	if s.ExcludedNamespaces.Has(namespace.Name) {
+		return MismatchReasonExcludedByInjector
+	}
+	// a SidecarGo only injects the pods of its own namespace
+	if sidecarGoNamespace, _, ok := strings.Cut(namespacedName, "/"); ok && sidecarGoNamespace != namespace.Name {
+		return MismatchReasonNamespace
+	}
+	if spec.Namespace != "" && spec.Namespace != namespace.Name {
+		return MismatchReasonNamespace
+	}
+	namespaceSelector, hasNamespaceSelector := s.namespaceSelectors[namespacedName]
+	if hasNamespaceSelector && !namespaceSelector.Matches(labels.Set(namespace.Labels)) {
+		return MismatchReasonNamespaceSelector
+	}
+	if reason := s.podMatcherMismatchReason(namespacedName, spec, pod, ownerKind); reason != "" {
+		return reason
+	}
+	if spec.Requestable && s.podRequested(namespacedName, pod, namespace) {
+		return ""
+	}
+	if selector, ok := s.selectors[namespacedName]; ok {
+		if selector.Empty() || !selector.Matches(labels.Set(pod.Labels)) {
+			return MismatchReasonSelector
+		}
+		return ""
+	}
+	if spec.Namespace != "" || (hasNamespaceSelector && !namespaceSelector.Empty()) || hasPodMatchers(spec) {
+		return ""
+	}
+	return MismatchReasonNoSelector
+}
@@ -0,0 +1,288 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/togettoyou/sidecar-go/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodMismatchReason(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Labels:      map[string]string{"app": "web", "canary": "true"},
+			Annotations: map[string]string{"mesh": "enabled"},
+		},
+		Spec: corev1.PodSpec{
+			ServiceAccountName: "web",
+			PriorityClassName:  "high",
+			NodeSelector:       map[string]string{"disk": "ssd"},
+			Containers:         []corev1.Container{{Name: "web", Image: "nginx:1.23"}},
+		},
+	}
+	requested := pod.DeepCopy()
+	requested.Labels = nil
+	requested.Annotations = map[string]string{AnnotationInject: "proxy"}
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "prod", Labels: map[string]string{"env": "prod"}},
+	}
+	scheduled := pod.DeepCopy()
+	scheduled.Spec.NodeSelector = nil
+	scheduled.Spec.NodeName = "node-1"
+	webSelector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+
+	tests := []struct {
+		name      string
+		key       string
+		spec      *v1alpha1.SidecarGoSpec
+		pod       *corev1.Pod
+		ownerKind string
+		want      string
+	}{
+		{
+			name: "matched by selector",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{Selector: webSelector},
+			want: "",
+		},
+		{
+			name: "no selector",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{},
+			want: MismatchReasonNoSelector,
+		},
+		{
+			name: "empty selector matches nothing",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{Selector: &metav1.LabelSelector{}},
+			want: MismatchReasonSelector,
+		},
+		{
+			name: "namespace restricts without selector",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{Namespace: "prod"},
+			want: "",
+		},
+		{
+			name: "SidecarGo of another namespace first",
+			key:  "dev/proxy",
+			spec: &v1alpha1.SidecarGoSpec{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "dev"}},
+				Selector:          &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			},
+			want: MismatchReasonNamespace,
+		},
+		{
+			name: "namespace selector before pod matchers",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "dev"}},
+				OwnerKinds:        []string{"StatefulSet"},
+			},
+			want: MismatchReasonNamespaceSelector,
+		},
+		{
+			name: "owner kind before service account",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{
+				OwnerKinds:          []string{"StatefulSet"},
+				ServiceAccountNames: []string{"db"},
+			},
+			ownerKind: "Deployment",
+			want:      MismatchReasonOwnerKind,
+		},
+		{
+			name: "service account before priority class",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{
+				OwnerKinds:          []string{"Deployment"},
+				ServiceAccountNames: []string{"db"},
+				PriorityClassNames:  []string{"low"},
+			},
+			ownerKind: "Deployment",
+			want:      MismatchReasonServiceAccount,
+		},
+		{
+			name: "priority class before annotation selector",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{
+				PriorityClassNames: []string{"low"},
+				AnnotationSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"mesh": "disabled"}},
+			},
+			want: MismatchReasonPriorityClass,
+		},
+		{
+			name: "annotation selector before container selector",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{
+				AnnotationSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"mesh": "disabled"}},
+				ContainerSelector:  &v1alpha1.ContainerSelector{Images: []string{"redis:*"}},
+			},
+			want: MismatchReasonAnnotationSelector,
+		},
+		{
+			name: "container selector before selector",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{
+				ContainerSelector: &v1alpha1.ContainerSelector{Images: []string{"redis:*"}},
+				Selector:          &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			},
+			want: MismatchReasonContainer,
+		},
+		{
+			name: "container selector before node selector",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{
+				ContainerSelector: &v1alpha1.ContainerSelector{Images: []string{"redis:*"}},
+				NodeSelector:      &metav1.LabelSelector{MatchLabels: map[string]string{"disk": "hdd"}},
+			},
+			want: MismatchReasonContainer,
+		},
+		{
+			name: "node selector before selector",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{
+				NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"disk": "hdd"}},
+				Selector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			},
+			want: MismatchReasonNodeSelector,
+		},
+		{
+			name: "node selector matches the nodeSelector of the pod",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"disk": "ssd"}}},
+			want: "",
+		},
+		{
+			name: "node selector matches the nodeName of the pod as hostname",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{NodeSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: corev1.LabelHostname, Operator: metav1.LabelSelectorOpIn, Values: []string{"node-1", "node-2"}},
+				},
+			}},
+			pod:  scheduled,
+			want: "",
+		},
+		{
+			name: "node selector does not match a pod without the node label",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"disk": "ssd"}}},
+			pod:  scheduled,
+			want: MismatchReasonNodeSelector,
+		},
+		{
+			name: "pod matchers restrict without selector",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{ContainerSelector: &v1alpha1.ContainerSelector{Images: []string{"nginx:*"}}},
+			want: "",
+		},
+		{
+			name: "selector before exclusions",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{
+				Selector:          &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+				ExcludeNamespaces: []string{"prod"},
+			},
+			want: MismatchReasonSelector,
+		},
+		{
+			name: "excluded namespace before exclude selector",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{
+				Selector:          webSelector,
+				ExcludeNamespaces: []string{"prod"},
+				ExcludeSelector:   &metav1.LabelSelector{MatchLabels: map[string]string{"canary": "true"}},
+			},
+			want: MismatchReasonExcludeNamespace,
+		},
+		{
+			name: "exclude selector",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{
+				Selector:        webSelector,
+				ExcludeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"canary": "true"}},
+			},
+			want: MismatchReasonExcludeSelector,
+		},
+		{
+			name: "requested pod matches regardless of the selector",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{Requestable: true, Selector: webSelector},
+			pod:  requested,
+			want: "",
+		},
+		{
+			name: "requested pod is still restricted by the pod matchers",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{Requestable: true, PriorityClassNames: []string{"low"}},
+			pod:  requested,
+			want: MismatchReasonPriorityClass,
+		},
+		{
+			name: "requested pod is still excluded",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{Requestable: true, ExcludeNamespaces: []string{"prod"}},
+			pod:  requested,
+			want: MismatchReasonExcludeNamespace,
+		},
+		{
+			name: "pod requesting a SidecarGo that is not requestable",
+			key:  "proxy",
+			spec: &v1alpha1.SidecarGoSpec{Selector: webSelector},
+			pod:  requested,
+			want: MismatchReasonSelector,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSpecStore(nil, nil, "")
+			if err := s.Update(tt.key, tt.spec); err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+			p := tt.pod
+			if p == nil {
+				p = pod
+			}
+			if got := s.podMismatchReason(tt.key, s.specs[tt.key], p, namespace, tt.ownerKind); got != tt.want {
+				t.Errorf("podMismatchReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    *v1alpha1.SidecarGoSpec
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			spec: &v1alpha1.SidecarGoSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}},
+		},
+		{
+			name: "invalid selector",
+			spec: &v1alpha1.SidecarGoSpec{Selector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Matches"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "invalid exclude selector",
+			spec: &v1alpha1.SidecarGoSpec{ExcludeSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: metav1.LabelSelectorOpIn}},
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateSpec(tt.spec); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSpec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/togettoyou/sidecar-go/api/v1alpha1"
+	"github.com/togettoyou/sidecar-go/pkg/template"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// TemplateData is what the templates of a templated SidecarGo are rendered against.
+type TemplateData struct {
+	// Name is the name of the pod, or its generateName if it is not named yet.
+	Name      string
+	Namespace string
+	// Labels and Annotations of the pod, before injection.
+	Labels      map[string]string
+	Annotations map[string]string
+	// NamespaceLabels are the labels of the namespace of the pod.
+	NamespaceLabels    map[string]string
+	ServiceAccountName string
+	// Containers are the containers of the pod, before injection.
+	Containers []corev1.Container
+}
+
+// NewTemplateData returns the data of the pod in the given namespace, before injection.
+func NewTemplateData(pod *corev1.Pod, namespace *corev1.Namespace) *TemplateData {
+	data := &TemplateData{
+		Name:               pod.Name,
+		Namespace:          namespace.Name,
+		Labels:             make(map[string]string),
+		Annotations:        make(map[string]string),
+		NamespaceLabels:    make(map[string]string),
+		ServiceAccountName: pod.Spec.ServiceAccountName,
+		Containers:         make([]corev1.Container, 0, len(pod.Spec.Containers)),
+	}
+	if data.Name == "" {
+		data.Name = pod.GenerateName
+	}
+	for k, v := range pod.Labels {
+		data.Labels[k] = v
+	}
+	for k, v := range pod.Annotations {
+		data.Annotations[k] = v
+	}
+	for k, v := range namespace.Labels {
+		data.NamespaceLabels[k] = v
+	}
+	for _, container := range pod.Spec.Containers {
+		data.Containers = append(data.Containers, *container.DeepCopy())
+	}
+	return data
+}
+
+// Container returns the container of the pod with the given name.
+func (d *TemplateData) Container(name string) (*corev1.Container, error) {
+	for i := range d.Containers {
+		if d.Containers[i].Name == name {
+			return &d.Containers[i], nil
+		}
+	}
+	return nil, fmt.Errorf("pod has no container %s", name)
+}
+
+// ContainerPort returns the first port of the container of the pod with the given name.
+func (d *TemplateData) ContainerPort(name string) (int32, error) {
+	container, err := d.Container(name)
+	if err != nil {
+		return 0, err
+	}
+	if len(container.Ports) == 0 {
+		return 0, fmt.Errorf("container %s has no port", name)
+	}
+	return container.Ports[0].ContainerPort, nil
+}
+
+// ValidateTemplates parses the templates of a templated spec.
+func ValidateTemplates(spec *v1alpha1.SidecarGoSpec) error {
+	if !spec.Templated {
+		return nil
+	}
+	for _, part := range templatedParts(spec, nil) {
+		if err := template.Validate(part.field, part.in); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenderSpec returns a copy of the spec with its templates rendered against data,
+// or the spec itself if it is not templated.
+func RenderSpec(spec *v1alpha1.SidecarGoSpec, data *TemplateData) (*v1alpha1.SidecarGoSpec, error) {
+	if !spec.Templated {
+		return spec, nil
+	}
+	render := func(text string) (string, error) {
+		return template.Render(text, data)
+	}
+
+	rendered := spec.DeepCopy()
+	for _, part := range templatedParts(spec, rendered) {
+		raw, err := template.RenderJSON(part.field, part.in, render)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, part.out); err != nil {
+			return nil, fmt.Errorf("%s: %w", part.field, err)
+		}
+	}
+	return rendered, nil
+}
+
+// templatedPart is a part of the spec whose strings are templates.
+type templatedPart struct {
+	field string
+	in    interface{}
+	// out points to the part in the rendered spec.
+	out interface{}
+}
+
+func templatedParts(spec, rendered *v1alpha1.SidecarGoSpec) []templatedPart {
+	if rendered == nil {
+		rendered = &v1alpha1.SidecarGoSpec{}
+	}
+	// the rendered parts are decoded from scratch
+	rendered.InitContainers, rendered.Containers, rendered.Volumes, rendered.ContainerPatches = nil, nil, nil, nil
+	return []templatedPart{
+		{"initContainers", spec.InitContainers, &rendered.InitContainers},
+		{"containers", spec.Containers, &rendered.Containers},
+		{"volumes", spec.Volumes, &rendered.Volumes},
+		{"containerPatches", spec.ContainerPatches, &rendered.ContainerPatches},
+	}
+}
@@ -0,0 +1,125 @@
+package util
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/togettoyou/sidecar-go/api/v1alpha1"
+	"github.com/togettoyou/sidecar-go/pkg/template"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRenderSpec(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "web-",
+			Labels:       map[string]string{"app": "web"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "web", Ports: []corev1.ContainerPort{{ContainerPort: 8080}}},
+			},
+		},
+	}
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "prod", Labels: map[string]string{"team": "Payments"}},
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		want     []string
+		wantPath string
+	}{
+		{
+			name: "pod fields",
+			args: []string{"--service={{ .Labels.app }}.{{ .Namespace }}", "--pod={{ .Name }}"},
+			want: []string{"--service=web.prod", "--pod=web-"},
+		},
+		{
+			name: "methods and functions",
+			args: []string{"--port={{ .ContainerPort \"web\" }}", "--team={{ lower .NamespaceLabels.team }}", "--sa={{ default \"default\" .ServiceAccountName }}"},
+			want: []string{"--port=8080", "--team=payments", "--sa=default"},
+		},
+		{
+			name: "plain strings are kept",
+			args: []string{"--verbose"},
+			want: []string{"--verbose"},
+		},
+		{
+			name:     "required value missing",
+			args:     []string{"--verbose", "{{ required \"label version\" (index .Labels \"version\") }}"},
+			wantPath: "containers[0].args[1]",
+		},
+		{
+			name:     "missing container",
+			args:     []string{"{{ .ContainerPort \"db\" }}"},
+			wantPath: "containers[0].args[0]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &v1alpha1.SidecarGoSpec{
+				Templated:  true,
+				Containers: []corev1.Container{{Name: "proxy", Args: tt.args}},
+			}
+			rendered, err := RenderSpec(spec, NewTemplateData(pod, namespace))
+			if tt.wantPath != "" {
+				var templateErr *template.Error
+				if !errors.As(err, &templateErr) || templateErr.Path != tt.wantPath {
+					t.Fatalf("RenderSpec() error = %v, want a template error at %s", err, tt.wantPath)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RenderSpec() error = %v", err)
+			}
+			if got := rendered.Containers[0].Args; !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("RenderSpec() args = %q, want %q", got, tt.want)
+			}
+			if !equality.Semantic.DeepEqual(spec.Containers[0].Args, tt.args) {
+				t.Errorf("RenderSpec() modified the spec: %q", spec.Containers[0].Args)
+			}
+		})
+	}
+}
+
+func TestRenderSpecNotTemplated(t *testing.T) {
+	spec := &v1alpha1.SidecarGoSpec{
+		Containers: []corev1.Container{{Name: "proxy", Args: []string{"{{ .Name }}"}}},
+	}
+	rendered, err := RenderSpec(spec, NewTemplateData(&corev1.Pod{}, &corev1.Namespace{}))
+	if err != nil {
+		t.Fatalf("RenderSpec() error = %v", err)
+	}
+	if rendered != spec {
+		t.Errorf("RenderSpec() = %+v, want the spec itself", rendered)
+	}
+}
+
+func TestValidateTemplates(t *testing.T) {
+	tests := []struct {
+		name      string
+		templated bool
+		image     string
+		wantErr   bool
+	}{
+		{name: "valid", templated: true, image: "proxy:{{ default \"latest\" .Labels.version }}"},
+		{name: "unknown function", templated: true, image: "proxy:{{ env \"VERSION\" }}", wantErr: true},
+		{name: "unclosed action", templated: true, image: "proxy:{{ .Labels.version", wantErr: true},
+		{name: "not templated", templated: false, image: "proxy:{{ .Labels.version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &v1alpha1.SidecarGoSpec{
+				Templated:  tt.templated,
+				Containers: []corev1.Container{{Name: "proxy", Image: tt.image}},
+			}
+			if err := ValidateTemplates(spec); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateTemplates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}